@@ -0,0 +1,185 @@
+// Package anomaly watches scans for signs of proxy attendance. Every valid scan is run
+// through a set of rules; a student a rule is sure enough about is flagged on the
+// teacher's live feed and kept for the post-session report.
+package anomaly
+
+import (
+	"cmp"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/database"
+	"github.com/anuragrao04/qr-attendance-backend/models"
+	"github.com/anuragrao04/qr-attendance-backend/sessions"
+)
+
+// EventAnomalyFlag is pushed to the teacher with a models.AnomalyFlag payload
+const EventAnomalyFlag = "ANOMALY_FLAG"
+
+// Finding is a rule's verdict on a scan. Score runs from 0, nothing unusual,
+// to 1, almost certainly a proxy
+type Finding struct {
+	Score  float64
+	Reason string
+	SRNs   []string // the students it implicates, the scanning student if empty
+}
+
+// Rule looks at a valid scan in the light of the session's earlier valid scans
+type Rule interface {
+	Name() string
+	Check(scan models.ScanAttempt, history []models.ScanAttempt) (Finding, bool)
+}
+
+// Detector runs scans through its rules and flags students once a finding scores at
+// least Threshold. A student is flagged at most once per rule and session
+type Detector struct {
+	Rules     []Rule
+	Threshold float64
+
+	mutex   sync.Mutex
+	history map[models.ID][]models.ScanAttempt // session -> valid scans so far
+	flagged map[models.ID]map[string]bool      // session -> rule + SRN
+}
+
+// NewDetector makes a detector with the given rules
+func NewDetector(threshold float64, rules ...Rule) *Detector {
+	return &Detector{
+		Rules:     rules,
+		Threshold: threshold,
+		history:   make(map[models.ID][]models.ScanAttempt),
+		flagged:   make(map[models.ID]map[string]bool),
+	}
+}
+
+// Observe scores one scan and returns the flags it raises. Rejected scans are ignored,
+// they didn't mark anyone present
+func (d *Detector) Observe(scan models.ScanAttempt) []models.AnomalyFlag {
+	if scan.Outcome == models.ScanOutcomeRejected {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	history := d.history[scan.SessionID]
+	var flags []models.AnomalyFlag
+	for _, rule := range d.Rules {
+		finding, found := rule.Check(scan, history)
+		if !found || finding.Score < d.Threshold {
+			continue
+		}
+		srns := finding.SRNs
+		if len(srns) == 0 {
+			srns = []string{scan.SRN}
+		}
+		for _, SRN := range srns {
+			if d.flagged[scan.SessionID] == nil {
+				d.flagged[scan.SessionID] = make(map[string]bool)
+			}
+			if d.flagged[scan.SessionID][rule.Name()+" "+SRN] {
+				continue
+			}
+			d.flagged[scan.SessionID][rule.Name()+" "+SRN] = true
+			flags = append(flags, models.AnomalyFlag{
+				CreatedAt: scan.CreatedAt, // within the session, even if the flag is stored after it ends
+				SessionID: scan.SessionID,
+				SRN:       SRN,
+				ClientIP:  clientIPOf(SRN, scan, history),
+				Rule:      rule.Name(),
+				Score:     finding.Score,
+				Reason:    finding.Reason,
+			})
+		}
+	}
+
+	d.history[scan.SessionID] = append(history, scan)
+	return flags
+}
+
+// Forget drops what the detector knows of a session
+func (d *Detector) Forget(sessionID models.ID) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.history, sessionID)
+	delete(d.flagged, sessionID)
+}
+
+// sessionIDs lists the sessions the detector holds state for
+func (d *Detector) sessionIDs() []models.ID {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	ids := make([]models.ID, 0, len(d.history))
+	for id := range d.history {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// the address a student last scanned from
+func clientIPOf(SRN string, scan models.ScanAttempt, history []models.ScanAttempt) string {
+	if scan.SRN == SRN {
+		return scan.ClientIP
+	}
+	for _, earlier := range slices.Backward(history) {
+		if earlier.SRN == SRN {
+			return earlier.ClientIP
+		}
+	}
+	return ""
+}
+
+// Start feeds every recorded scan to the detector, storing and announcing its flags,
+// and forgets sessions once they end
+func Start(d *Detector) {
+	scans := sessions.RegisterForScanAttempts()
+	go func() {
+		for scan := range scans {
+			for _, flag := range d.Observe(scan) {
+				if flag.CreatedAt.IsZero() {
+					flag.CreatedAt = sessions.Now()
+				}
+				log.Printf("SRN %s flagged by %s in session %d: %s", flag.SRN, flag.Rule, flag.SessionID, flag.Reason)
+				if err := database.CreateAnomalyFlag(&flag); err != nil {
+					log.Printf("Failed to store anomaly flag of %s: %v", flag.SRN, err)
+				}
+				sessions.PublishSessionEvent(flag.SessionID, EventAnomalyFlag, flag)
+			}
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			for _, sessionID := range d.sessionIDs() {
+				if _, err := sessions.SessionOwner(sessionID); err != nil {
+					d.Forget(sessionID)
+				}
+			}
+		}
+	}()
+}
+
+// Summarize groups a session's flags by student, the most suspicious first
+func Summarize(flags []models.AnomalyFlag) []models.StudentAnomalies {
+	var students []models.StudentAnomalies
+	index := make(map[string]int)
+	for _, flag := range flags {
+		i, exists := index[flag.SRN]
+		if !exists {
+			i = len(students)
+			index[flag.SRN] = i
+			students = append(students, models.StudentAnomalies{SRN: flag.SRN})
+		}
+		students[i].Score = max(students[i].Score, flag.Score)
+		if !slices.Contains(students[i].Rules, flag.Rule) {
+			students[i].Rules = append(students[i].Rules, flag.Rule)
+		}
+	}
+	slices.SortStableFunc(students, func(a, b models.StudentAnomalies) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	return students
+}
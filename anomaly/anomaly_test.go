@@ -0,0 +1,207 @@
+package anomaly
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+const testSessionID = 4242
+
+// scan makes a valid scan with a fingerprint and address nobody else shares
+func scan(i int) models.ScanAttempt {
+	return models.ScanAttempt{
+		SRN:            fmt.Sprintf("PES1UG21CS%03d", i),
+		SessionID:      testSessionID,
+		ClientIP:       fmt.Sprintf("10.0.0.%d", i),
+		ClockDrift:     int64(1000 + 37*i),
+		StudentLatency: int64(20 + 5*i),
+		RoundTrip:      int64(40 + 10*i),
+		ConnectedAt:    1_700_000_000_000,
+		TokenCreatedAt: 1_700_000_001_000,
+		Outcome:        models.ScanOutcomeAccepted,
+	}
+}
+
+// flagged lists the SRNs flagged by a rule, in order
+func flagged(flags []models.AnomalyFlag, rule string) []string {
+	var srns []string
+	for _, flag := range flags {
+		if flag.Rule == rule {
+			srns = append(srns, flag.SRN)
+		}
+	}
+	return srns
+}
+
+func TestSharedIP(t *testing.T) {
+	detector := NewDetector(0.5, SharedIP{MaxSRNs: 2})
+
+	var flags []models.AnomalyFlag
+	for i := 1; i <= 4; i++ {
+		s := scan(i)
+		s.ClientIP = "10.1.1.1"
+		flags = append(flags, detector.Observe(s)...)
+	}
+
+	// the third student tips it over and takes the first two with them, the fourth joins later
+	want := []string{"PES1UG21CS003", "PES1UG21CS001", "PES1UG21CS002", "PES1UG21CS004"}
+	if got := flagged(flags, "SHARED_IP"); !slices.Equal(got, want) {
+		t.Errorf("got %v flagged, want %v", got, want)
+	}
+	for _, flag := range flags {
+		if flag.ClientIP != "10.1.1.1" {
+			t.Errorf("flag of %s has address %q", flag.SRN, flag.ClientIP)
+		}
+	}
+
+	// the campus NAT is in the session's allowed networks
+	for i := 5; i <= 8; i++ {
+		s := scan(i)
+		s.ClientIP = "10.20.0.1"
+		s.Network, s.NetworkResult = models.NetworkReject, models.NetworkAllowed
+		if flags := detector.Observe(s); len(flags) != 0 {
+			t.Errorf("a scan from an allowed network was flagged: %+v", flags)
+		}
+	}
+}
+
+func TestSharedFingerprint(t *testing.T) {
+	detector := NewDetector(0.5, SharedFingerprint{MinStudents: 3, Tolerance: 1})
+
+	var flags []models.AnomalyFlag
+	for i := 1; i <= 5; i++ {
+		s := scan(i)
+		if i%2 == 1 {
+			// 1, 3 and 5 scanned on the same phone, a millisecond apart at most
+			s.ClockDrift, s.StudentLatency, s.RoundTrip = 5000+int64(i/3), 30, 60
+		}
+		flags = append(flags, detector.Observe(s)...)
+	}
+
+	want := []string{"PES1UG21CS005", "PES1UG21CS001", "PES1UG21CS003"}
+	if got := flagged(flags, "SHARED_FINGERPRINT"); !slices.Equal(got, want) {
+		t.Errorf("got %v flagged, want %v", got, want)
+	}
+}
+
+func TestTokenBeforeConnect(t *testing.T) {
+	tests := []struct {
+		name           string
+		tokenCreatedAt int64 // ms before connecting
+		teacherLatency int64
+		want           bool
+	}{
+		{name: "QR shown after connecting", tokenCreatedAt: -1000},
+		{name: "QR on screen while connecting", tokenCreatedAt: 150},
+		{name: "QR gone just before connecting", tokenCreatedAt: 201, want: true},
+		{name: "slow projector kept it up", tokenCreatedAt: 201, teacherLatency: 50},
+		{name: "long gone", tokenCreatedAt: 60_000, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := scan(1)
+			s.TokenCreatedAt = s.ConnectedAt - tt.tokenCreatedAt
+			s.TeacherLatency = tt.teacherLatency
+			if _, got := (TokenBeforeConnect{}).Check(s, nil); got != tt.want {
+				t.Errorf("got flagged %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectorIgnoresRejectedAndForgets(t *testing.T) {
+	detector := NewDetector(0.5, SharedIP{MaxSRNs: 1})
+
+	rejected := scan(1)
+	rejected.ClientIP = "10.1.1.1"
+	rejected.Outcome = models.ScanOutcomeRejected
+	detector.Observe(rejected)
+
+	first := scan(2)
+	first.ClientIP = "10.1.1.1"
+	if flags := detector.Observe(first); len(flags) != 0 {
+		t.Errorf("a rejected scan counted towards the address: %+v", flags)
+	}
+
+	detector.Forget(testSessionID)
+	second := scan(3)
+	second.ClientIP = "10.1.1.1"
+	if flags := detector.Observe(second); len(flags) != 0 {
+		t.Errorf("a forgotten session still counted: %+v", flags)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	flags := []models.AnomalyFlag{
+		{SRN: "A", Rule: "SHARED_IP", Score: 0.6},
+		{SRN: "B", Rule: "SHARED_IP", Score: 0.6},
+		{SRN: "B", Rule: "TOKEN_BEFORE_CONNECT", Score: 1},
+		{SRN: "A", Rule: "SHARED_IP", Score: 0.6},
+	}
+	got := Summarize(flags)
+	want := []models.StudentAnomalies{
+		{SRN: "B", Score: 1, Rules: []string{"SHARED_IP", "TOKEN_BEFORE_CONNECT"}},
+		{SRN: "A", Score: 0.6, Rules: []string{"SHARED_IP"}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i].SRN != want[i].SRN || got[i].Score != want[i].Score || !slices.Equal(got[i].Rules, want[i].Rules) {
+			t.Errorf("student %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestOutsideGeofence(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		result string
+		want   bool
+	}{
+		{name: "outside a flagging fence", mode: models.GeofenceFlag, result: models.LocationOutside, want: true},
+		{name: "inside a flagging fence", mode: models.GeofenceFlag, result: models.LocationInside},
+		{name: "no location", mode: models.GeofenceFlag, result: models.LocationMissing},
+		{name: "outside an ignoring fence", mode: models.GeofenceIgnore, result: models.LocationOutside},
+		{name: "no fence"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := scan(1)
+			s.Geofence, s.LocationResult = tt.mode, tt.result
+			if _, got := (OutsideGeofence{}).Check(s, nil); got != tt.want {
+				t.Errorf("got flagged %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOffNetwork(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		result string
+		want   bool
+	}{
+		{name: "off network under a flagging restriction", mode: models.NetworkFlag, result: models.NetworkOutside, want: true},
+		{name: "on campus", mode: models.NetworkFlag, result: models.NetworkAllowed},
+		{name: "off network under an ignoring restriction", mode: models.NetworkIgnore, result: models.NetworkOutside},
+		{name: "no restriction"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := scan(1)
+			s.Network, s.NetworkResult = tt.mode, tt.result
+			if _, got := (OffNetwork{}).Check(s, nil); got != tt.want {
+				t.Errorf("got flagged %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package anomaly
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+	"github.com/anuragrao04/qr-attendance-backend/sessions"
+)
+
+// DefaultRules is what the server runs. A maxSRNsPerIP of 0 leaves SharedIP out
+func DefaultRules(maxSRNsPerIP int) []Rule {
+	rules := []Rule{
+		SharedFingerprint{MinStudents: 3, Tolerance: 1},
+		TokenBeforeConnect{},
+		OutsideGeofence{},
+		OffNetwork{},
+	}
+	if maxSRNsPerIP > 0 {
+		rules = append(rules, SharedIP{MaxSRNs: maxSRNsPerIP})
+	}
+	return rules
+}
+
+// SharedIP flags every student of an address once more than MaxSRNs students marked
+// attendance from it. Addresses inside the session's allowed networks are skipped, a
+// whole class may share one behind the campus NAT
+type SharedIP struct {
+	MaxSRNs int
+}
+
+func (SharedIP) Name() string { return "SHARED_IP" }
+
+func (r SharedIP) Check(scan models.ScanAttempt, history []models.ScanAttempt) (Finding, bool) {
+	if scan.ClientIP == "" || scan.NetworkResult == models.NetworkAllowed {
+		return Finding{}, false
+	}
+	srns := distinctSRNs(scan, history, func(earlier models.ScanAttempt) bool {
+		return earlier.ClientIP == scan.ClientIP
+	})
+	if len(srns) <= r.MaxSRNs {
+		return Finding{}, false
+	}
+	return Finding{
+		Score:  0.6,
+		Reason: fmt.Sprintf("%d students marked present from %s", len(srns), scan.ClientIP),
+		SRNs:   srns,
+	}, true
+}
+
+// SharedFingerprint flags students whose clock drift, latency and round trip all match
+// to within Tolerance ms, as happens when one phone scans for several people
+type SharedFingerprint struct {
+	MinStudents int
+	Tolerance   int64
+}
+
+func (SharedFingerprint) Name() string { return "SHARED_FINGERPRINT" }
+
+func (r SharedFingerprint) Check(scan models.ScanAttempt, history []models.ScanAttempt) (Finding, bool) {
+	srns := distinctSRNs(scan, history, func(earlier models.ScanAttempt) bool {
+		return within(earlier.ClockDrift, scan.ClockDrift, r.Tolerance) &&
+			within(earlier.StudentLatency, scan.StudentLatency, r.Tolerance) &&
+			within(earlier.RoundTrip, scan.RoundTrip, r.Tolerance)
+	})
+	if len(srns) < r.MinStudents {
+		return Finding{}, false
+	}
+	return Finding{
+		Score:  0.8,
+		Reason: fmt.Sprintf("%d students share clock drift %d ms and latency %d ms", len(srns), scan.ClockDrift, scan.StudentLatency),
+		SRNs:   srns,
+	}, true
+}
+
+// TokenBeforeConnect flags a scan of a QR that was off the teacher's screen before the
+// student's socket even opened. Their own camera can't have seen it; someone sent it to them
+type TokenBeforeConnect struct{}
+
+func (TokenBeforeConnect) Name() string { return "TOKEN_BEFORE_CONNECT" }
+
+func (TokenBeforeConnect) Check(scan models.ScanAttempt, history []models.ScanAttempt) (Finding, bool) {
+	if scan.TokenCreatedAt == 0 || scan.ConnectedAt == 0 {
+		return Finding{}, false
+	}
+	// the QR stays up for its lifetime, and reaches the screen TeacherLatency late
+	offScreenAt := scan.TokenCreatedAt + sessions.RandomIDLifetime.Milliseconds() + scan.TeacherLatency
+	if offScreenAt >= scan.ConnectedAt {
+		return Finding{}, false
+	}
+	return Finding{
+		Score:  1,
+		Reason: fmt.Sprintf("scanned a QR that left the screen %d ms before connecting", scan.ConnectedAt-offScreenAt),
+	}, true
+}
+
+// OutsideGeofence flags a scan the session's geofence let through, though it put the
+// phone outside the classroom. Fences that reject such scans, or ignore them, never get here
+type OutsideGeofence struct{}
+
+func (OutsideGeofence) Name() string { return "OUTSIDE_GEOFENCE" }
+
+func (OutsideGeofence) Check(scan models.ScanAttempt, history []models.ScanAttempt) (Finding, bool) {
+	if scan.Geofence != models.GeofenceFlag || scan.LocationResult != models.LocationOutside {
+		return Finding{}, false
+	}
+	return Finding{
+		Score:  0.7,
+		Reason: fmt.Sprintf("scanned %.0f m from the classroom, give or take %.0f m", scan.LocationDistance, scan.LocationAccuracy),
+	}, true
+}
+
+// OffNetwork flags a scan the session's network restriction let through, though it came
+// from outside the allowed networks
+type OffNetwork struct{}
+
+func (OffNetwork) Name() string { return "OFF_NETWORK" }
+
+func (OffNetwork) Check(scan models.ScanAttempt, history []models.ScanAttempt) (Finding, bool) {
+	if scan.Network != models.NetworkFlag || scan.NetworkResult != models.NetworkOutside {
+		return Finding{}, false
+	}
+	return Finding{
+		Score:  0.7,
+		Reason: fmt.Sprintf("scanned from %s, outside the classroom's networks", scan.ClientIP),
+	}, true
+}
+
+// distinctSRNs lists the scanning student and every other student with a matching earlier scan
+func distinctSRNs(scan models.ScanAttempt, history []models.ScanAttempt, matches func(models.ScanAttempt) bool) []string {
+	srns := []string{scan.SRN}
+	for _, earlier := range history {
+		if matches(earlier) && !slices.Contains(srns, earlier.SRN) {
+			srns = append(srns, earlier.SRN)
+		}
+	}
+	return srns
+}
+
+func within(a, b, tolerance int64) bool {
+	return max(a-b, b-a) <= tolerance
+}
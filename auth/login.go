@@ -66,5 +66,6 @@ func FinishLogin(c *gin.Context) {
 	}
 
 	WebAuthnLoginSessions.Delete(SRN)
+	issueSession(c, SRN)
 	c.JSON(http.StatusOK, gin.H{"message": "success"})
 }
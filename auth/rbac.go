@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/anuragrao04/qr-attendance-backend/database"
+	"github.com/gin-gonic/gin"
+)
+
+// IdentityKey is where RequirePermission stores the caller's SRN (or staff ID) in the gin context
+const IdentityKey = "identity"
+
+// RequirePermission only lets the request through if the caller, identified by
+// the signed session cookie issued at login, holds a role granting the permission
+func RequirePermission(permission string) gin.HandlerFunc {
+	return RequireAnyPermission(permission)
+}
+
+// RequireAnyPermission is RequirePermission for routes reachable through several permissions
+func RequireAnyPermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		SRN, err := sessionIdentity(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Not logged in"})
+			return
+		}
+
+		for _, permission := range permissions {
+			allowed, err := database.UserHasPermission(SRN, permission)
+			if err != nil {
+				log.Printf("Failed to check permission %s for %s: %v", permission, SRN, err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+				return
+			}
+			if allowed {
+				c.Set(IdentityKey, SRN)
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Missing permission " + strings.Join(permissions, " or ")})
+	}
+}
+
+// Identity returns the caller authenticated by RequirePermission
+func Identity(c *gin.Context) string {
+	return c.GetString(IdentityKey)
+}
+
+// HasPermission checks an additional permission of the caller inside a handler
+func HasPermission(c *gin.Context, permission string) bool {
+	allowed, err := database.UserHasPermission(Identity(c), permission)
+	if err != nil {
+		log.Printf("Failed to check permission %s for %s: %v", permission, Identity(c), err)
+		return false
+	}
+	return allowed
+}
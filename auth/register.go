@@ -39,6 +39,11 @@ func BeginRegistration(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "User already registered"})
 		return
 	}
+	if err := database.ResetUnregisteredRoles(SRN); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	options, session, err := WebAuthn.BeginRegistration(user, webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
 		AuthenticatorAttachment: protocol.Platform,
@@ -100,6 +105,7 @@ func FinishRegistration(c *gin.Context) {
 		true,             // Secure (true to allow only over HTTPS)
 		true,             // HttpOnly (true to disallow JavaScript access)
 	)
+	issueSession(c, SRN)
 	c.JSON(http.StatusOK, gin.H{"message": "success"})
 }
 
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionCookie holds the signed token proving who the caller logged in as
+const SessionCookie = "session"
+
+// SessionLifetime is how long a login stays valid before the passkey is needed again
+const SessionLifetime = 30 * 24 * time.Hour
+
+var ErrInvalidSession = errors.New("invalid session token")
+
+// sessionSecret signs session tokens. Random unless SetSessionSecret is called,
+// which logs everyone out on every restart
+var sessionSecret = randomSecret()
+
+func randomSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
+
+// SetSessionSecret makes tokens verifiable across restarts and replicas
+func SetSessionSecret(secret []byte) {
+	sessionSecret = secret
+}
+
+// SignSession returns a token for SRN valid until expiresAt, of the form
+// base64(SRN).expiry.base64(HMAC-SHA256 of the two)
+func SignSession(secret []byte, SRN string, expiresAt time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(SRN)) + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sessionMAC(secret, payload))
+}
+
+// VerifySession returns the SRN a token was issued to, if the signature holds and it hasn't expired
+func VerifySession(secret []byte, token string, now time.Time) (string, error) {
+	encodedSRN, rest, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrInvalidSession
+	}
+	expiry, encodedMAC, ok := strings.Cut(rest, ".")
+	if !ok {
+		return "", ErrInvalidSession
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, sessionMAC(secret, encodedSRN+"."+expiry)) {
+		return "", ErrInvalidSession
+	}
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || !now.Before(time.Unix(expiresAt, 0)) {
+		return "", ErrInvalidSession
+	}
+	SRN, err := base64.RawURLEncoding.DecodeString(encodedSRN)
+	if err != nil || len(SRN) == 0 {
+		return "", ErrInvalidSession
+	}
+	return string(SRN), nil
+}
+
+func sessionMAC(secret []byte, payload string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+// issueSession logs the caller in as SRN, after they proved holding its passkey
+func issueSession(c *gin.Context, SRN string) {
+	c.SetCookie(
+		SessionCookie,
+		SignSession(sessionSecret, SRN, time.Now().Add(SessionLifetime)),
+		int(SessionLifetime/time.Second),
+		"/",
+		"",
+		true,
+		true,
+	)
+}
+
+// sessionIdentity returns who the caller logged in as, from the session cookie
+func sessionIdentity(c *gin.Context) (string, error) {
+	token, err := c.Cookie(SessionCookie)
+	if err != nil {
+		return "", ErrInvalidSession
+	}
+	return VerifySession(sessionSecret, token, time.Now())
+}
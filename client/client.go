@@ -0,0 +1,79 @@
+// Package client speaks the teacher and student websocket protocols, for bots, load
+// tests and integration tests. It always negotiates the current protocol version.
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/anuragrao04/qr-attendance-backend/protocol"
+	"github.com/gorilla/websocket"
+)
+
+// Options says which server to talk to and as whom
+type Options struct {
+	BaseURL string // e.g. http://localhost:6969, ws:// and wss:// work too
+	Session string // identity, the signed session cookie the server sets at login
+	Origin  string // must be an origin the server accepts, defaults to http://localhost:3000
+	Dialer  *websocket.Dialer
+}
+
+// StatusError is a failure reported by the server in a Status reply
+type StatusError struct {
+	protocol.Status
+}
+
+func (e *StatusError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("%s: %s", e.Code, e.Message)
+	}
+	return e.Message
+}
+
+// dial opens a connection on the current protocol version to path with the given query
+func dial(ctx context.Context, opts Options, path string, query url.Values) (*protocol.Conn, error) {
+	base, err := url.Parse(opts.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	switch base.Scheme {
+	case "http":
+		base.Scheme = "ws"
+	case "https":
+		base.Scheme = "wss"
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/") + path
+	base.RawQuery = query.Encode()
+
+	origin := opts.Origin
+	if origin == "" {
+		origin = "http://localhost:3000"
+	}
+	header := http.Header{"Origin": {origin}}
+	if opts.Session != "" {
+		header.Set("Cookie", (&http.Cookie{Name: "session", Value: opts.Session}).String())
+	}
+
+	dialer := *websocket.DefaultDialer
+	if opts.Dialer != nil {
+		dialer = *opts.Dialer
+	}
+	dialer.Subprotocols = []string{protocol.CurrentSubprotocol}
+
+	ws, resp, err := dialer.DialContext(ctx, base.String(), header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("dialing %s: %s: %w", path, resp.Status, err)
+		}
+		return nil, fmt.Errorf("dialing %s: %w", path, err)
+	}
+	conn := protocol.NewConn(ws)
+	if conn.Version() != protocol.CurrentVersion {
+		conn.Close()
+		return nil, fmt.Errorf("server at %s doesn't speak protocol v%d", opts.BaseURL, protocol.CurrentVersion)
+	}
+	return conn, nil
+}
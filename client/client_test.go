@@ -0,0 +1,238 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+	"github.com/anuragrao04/qr-attendance-backend/protocol"
+	"github.com/gorilla/websocket"
+)
+
+// fakeServer speaks the current protocol on every path and runs script on each socket.
+// Like the real server, it only accepts the frontend's origin
+func fakeServer(t *testing.T, script func(r *http.Request, conn *protocol.Conn)) *httptest.Server {
+	t.Helper()
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{protocol.CurrentSubprotocol},
+		CheckOrigin:  func(r *http.Request) bool { return r.Header.Get("Origin") == "http://localhost:3000" },
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		conn := protocol.NewConn(ws)
+		defer conn.Close()
+		script(r, conn)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// expect reads the next frame and fails the test unless it is of msgType
+func expect(t *testing.T, conn *protocol.Conn, msgType string, payload interface{}) {
+	t.Helper()
+	var raw json.RawMessage
+	got, err := conn.Receive(&raw)
+	if err != nil {
+		t.Errorf("waiting for %s: %v", msgType, err)
+		return
+	}
+	if got != msgType {
+		t.Errorf("got %s, want %s", got, msgType)
+		return
+	}
+	if payload != nil {
+		if err := json.Unmarshal(raw, payload); err != nil {
+			t.Errorf("decoding %s: %v", msgType, err)
+		}
+	}
+}
+
+func TestOpenSessionAnswersTheProbe(t *testing.T) {
+	const renderLatency = 30 * time.Millisecond
+	server := fakeServer(t, func(r *http.Request, conn *protocol.Conn) {
+		if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "token" {
+			t.Errorf("got session cookie %v, %v", cookie, err)
+		}
+		if tables := r.URL.Query()["table"]; len(tables) != 2 || tables[0] != "CSE_A" || tables[1] != "CSE_B" {
+			t.Errorf("got tables %v", tables)
+		}
+
+		conn.Send(protocol.TypeLatencyProbe, models.RandomID{})
+		expect(t, conn, protocol.TypeLatencyProbeAck, nil)
+		ackedAt := time.Now()
+		var rendered protocol.RenderLatency
+		expect(t, conn, protocol.TypeRenderLatency, &rendered)
+		if waited := time.Since(ackedAt); rendered.Message != renderLatency.Milliseconds() || waited < renderLatency {
+			t.Errorf("got render latency %dms after %v, want %v", rendered.Message, waited, renderLatency)
+		}
+
+		conn.Send(protocol.TypeSessionStarted, protocol.SessionStarted{SessionID: 7, Students: []models.StudentInASession{{SRN: "PES1UG21CS001"}}})
+		conn.Send(protocol.TypeRandomID, models.RandomID{ID: 11})
+		// from v5 every later QR is a probe too
+		conn.Send(protocol.TypeLatencyProbe, models.RandomID{ID: 12})
+		expect(t, conn, protocol.TypeLatencyProbeAck, nil)
+		expect(t, conn, protocol.TypeRenderLatency, &rendered)
+	})
+
+	session, err := OpenSession(context.Background(),
+		Options{BaseURL: server.URL, Session: "token"},
+		SessionParams{ClassroomTables: []string{"CSE_A", "CSE_B"}, RenderLatency: renderLatency},
+	)
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+	defer session.Close()
+	if session.SessionID != 7 || len(session.Students) != 1 {
+		t.Errorf("got session %d with %d students", session.SessionID, len(session.Students))
+	}
+	for _, want := range []uint64{11, 12} {
+		if token := <-session.Tokens(); uint64(token.ID) != want {
+			t.Errorf("got token %d, want %d", token.ID, want)
+		}
+	}
+	for range session.Events() {
+		// drained until the server hangs up
+	}
+}
+
+func TestOpenSessionGivesUpWithTheContext(t *testing.T) {
+	server := fakeServer(t, func(r *http.Request, conn *protocol.Conn) {
+		conn.Send(protocol.TypeLatencyProbe, models.RandomID{})
+		conn.Receive(nil)
+		conn.Receive(nil)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := OpenSession(ctx, Options{BaseURL: server.URL}, SessionParams{ClassroomTables: []string{"CSE_A"}, RenderLatency: time.Hour})
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Errorf("got %v after %v, want the deadline", err, time.Since(start))
+	}
+}
+
+func TestSessionCommandsAndEvents(t *testing.T) {
+	server := fakeServer(t, func(r *http.Request, conn *protocol.Conn) {
+		if r.URL.Path != "/join-attendance-session" || r.URL.Query().Get("session") != "7" {
+			t.Errorf("joined %s", r.URL)
+		}
+		conn.Send(protocol.TypeSessionStarted, protocol.SessionStarted{SessionID: 7})
+
+		var command protocol.TeacherCommand
+		expect(t, conn, protocol.TypeToggleAttendance, &command)
+		if command.SRN != "PES1UG21CS001" || command.ReasonCode != "LATE_ARRIVAL" {
+			t.Errorf("got toggle %+v", command)
+		}
+		conn.Send(protocol.TypeAttendanceUpdate, protocol.AttendanceUpdate{Presentees: []models.StudentInASession{{SRN: "PES1UG21CS001", IsPresent: true}}})
+		conn.Send(protocol.TypeCommandResult, protocol.Status{Status: protocol.StatusOK, Message: "Attendance toggled successfully"})
+
+		expect(t, conn, protocol.TypeMarkSRNs, &command)
+		if len(command.SRNs) != 2 || command.IsPresent {
+			t.Errorf("got mark %+v", command)
+		}
+		conn.Send(protocol.TypeCommandResult, protocol.Status{Status: protocol.StatusError, Message: "you are not allowed to change attendance in this session"})
+		conn.Send("SESSION_ENDED", map[string]string{"reason": "ended"})
+	})
+
+	session, err := JoinSession(context.Background(), Options{BaseURL: server.URL}, 7)
+	if err != nil {
+		t.Fatalf("failed to join: %v", err)
+	}
+	defer session.Close()
+
+	if err := session.Toggle("PES1UG21CS001", "LATE_ARRIVAL", ""); err != nil {
+		t.Fatalf("failed to toggle: %v", err)
+	}
+	if err := session.MarkSRNs([]string{"PES1UG21CS001", "PES1UG21CS002"}, false, "", ""); err != nil {
+		t.Fatalf("failed to mark: %v", err)
+	}
+
+	var events []Event
+	for event := range session.Events() {
+		events = append(events, event)
+	}
+	if len(events) != 4 {
+		t.Fatalf("got %d events, want 4: %+v", len(events), events)
+	}
+	if update, ok := events[0].(AttendanceUpdate); !ok || len(update.Presentees) != 1 {
+		t.Errorf("got %+v, want the attendance update", events[0])
+	}
+	if result, ok := events[1].(CommandResult); !ok || result.Status.Status != protocol.StatusOK {
+		t.Errorf("got %+v, want the toggle's result", events[1])
+	}
+	if result, ok := events[2].(CommandResult); !ok || result.Status.Status != protocol.StatusError {
+		t.Errorf("got %+v, want the refused mark", events[2])
+	}
+	if event, ok := events[3].(SessionEvent); !ok || event.Type != "SESSION_ENDED" || string(event.Payload) != `{"reason":"ended"}` {
+		t.Errorf("got %+v, want SESSION_ENDED", events[3])
+	}
+}
+
+func TestStudentClockSyncAndScans(t *testing.T) {
+	skewed := time.UnixMilli(1_700_000_000_000)
+	server := fakeServer(t, func(r *http.Request, conn *protocol.Conn) {
+		for round := range 3 {
+			conn.Send(protocol.TypeTimePing, protocol.TimePing{ServerTime: int64(1000 + round), Round: round})
+			var pong protocol.TimePong
+			expect(t, conn, protocol.TypeTimePong, &pong)
+			if pong.ServerTime != int64(1000+round) || pong.ClientReceivedAt != skewed.UnixMilli() || pong.ClientSentAt != skewed.UnixMilli() {
+				t.Errorf("round %d: got pong %+v", round, pong)
+			}
+		}
+		conn.Send(protocol.TypeClockSynced, protocol.ClockSynced{Rounds: 3})
+
+		var scan models.ScanMessage
+		expect(t, conn, protocol.TypeScan, &scan)
+		if scan.SessionID != 7 || scan.ScannedRandomID != 11 || scan.ScannedAt != "1700000000000" || scan.Location != nil {
+			t.Errorf("got scan %+v", scan)
+		}
+		conn.Send(protocol.TypeScanResult, protocol.Status{Status: protocol.StatusError, Code: "TOKEN_EXPIRED", Message: "expired"})
+
+		// pings keep coming between scans
+		conn.Send(protocol.TypeTimePing, protocol.TimePing{ServerTime: 2000, Round: 3})
+		expect(t, conn, protocol.TypeTimePong, nil)
+
+		expect(t, conn, protocol.TypeScan, &scan)
+		if scan.Location == nil || scan.Location.Latitude != 12.9 {
+			t.Errorf("got scan %+v, want the location", scan)
+		}
+		conn.Send(protocol.TypeScanResult, protocol.Status{Status: protocol.StatusPending, Message: "waiting for the teacher"})
+		conn.Send(protocol.TypeScanResult, protocol.Status{Status: protocol.StatusOK, Message: "marked"})
+	})
+
+	student, err := ConnectStudentWithClock(context.Background(), Options{BaseURL: server.URL}, func() time.Time { return skewed })
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer student.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err = student.Scan(ctx, 7, models.RandomID{ID: 11})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != "TOKEN_EXPIRED" {
+		t.Errorf("got %v, want TOKEN_EXPIRED", err)
+	}
+
+	student.SetLocation(models.DeviceLocation{Latitude: 12.9, Longitude: 77.5, Accuracy: 10})
+	result, err := student.Scan(ctx, 7, models.RandomID{ID: 12})
+	if err != nil || result.Status != protocol.StatusPending {
+		t.Errorf("got %+v, %v, want pending", result, err)
+	}
+	result, err = student.Next(ctx)
+	if err != nil || result.Status != protocol.StatusOK {
+		t.Errorf("got %+v, %v, want the approval", result, err)
+	}
+	if _, err := student.Next(ctx); err == nil {
+		t.Error("got a result after the server hung up")
+	}
+}
@@ -0,0 +1,155 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+	"github.com/anuragrao04/qr-attendance-backend/protocol"
+)
+
+// Student is a student's scanning connection. The server closes it once attendance is marked
+type Student struct {
+	conn    *protocol.Conn
+	results chan protocol.Status
+	err     error // why the read loop stopped, set before results is closed
+	now     func() time.Time
+
+	location *models.DeviceLocation // sent with every scan once set
+}
+
+// ConnectStudent opens the scan socket and answers the server's clock sync rounds.
+// It returns once the server has measured the clock
+func ConnectStudent(ctx context.Context, opts Options) (*Student, error) {
+	return ConnectStudentWithClock(ctx, opts, time.Now)
+}
+
+// ConnectStudentWithClock is ConnectStudent for a student whose clock reads now(),
+// used for clock sync and scan timestamps. Handy to simulate a skewed phone
+func ConnectStudentWithClock(ctx context.Context, opts Options, now func() time.Time) (*Student, error) {
+	conn, err := dial(ctx, opts, "/scan-qr", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	student := &Student{
+		conn:    conn,
+		results: make(chan protocol.Status, 4),
+		now:     now,
+	}
+
+	for {
+		var raw json.RawMessage
+		msgType, err := conn.Receive(&raw)
+		if err == nil && msgType == protocol.TypeTimePing {
+			err = student.pong(raw)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("clock sync failed: %w", err)
+		}
+		if msgType == protocol.TypeClockSynced {
+			break
+		}
+	}
+
+	go student.read()
+	return student, nil
+}
+
+// pong answers a clock sync ping
+func (s *Student) pong(raw json.RawMessage) error {
+	receivedAt := s.now().UnixMilli()
+	var ping protocol.TimePing
+	if err := json.Unmarshal(raw, &ping); err != nil {
+		return err
+	}
+	return s.conn.Send(protocol.TypeTimePong, protocol.TimePong{
+		ServerTime:       ping.ServerTime,
+		ClientReceivedAt: receivedAt,
+		ClientSentAt:     s.now().UnixMilli(),
+	})
+}
+
+func (s *Student) read() {
+	defer close(s.results)
+
+	for {
+		var raw json.RawMessage
+		msgType, err := s.conn.Receive(&raw)
+		if err != nil {
+			s.err = err
+			return
+		}
+		if msgType == protocol.TypeTimePing {
+			if err := s.pong(raw); err != nil {
+				s.err = err
+				return
+			}
+			continue
+		}
+		if msgType != protocol.TypeScanResult {
+			continue
+		}
+
+		var result protocol.Status
+		if err := json.Unmarshal(raw, &result); err != nil {
+			s.err = err
+			return
+		}
+		s.results <- result
+	}
+}
+
+// Scan submits a scanned token, stamped with the student's clock, and waits for the
+// verdict. Rejections come back as a *StatusError carrying the scan error code. A
+// pending result means the teacher has to approve; wait for it with Next
+func (s *Student) Scan(ctx context.Context, sessionID models.ID, token models.RandomID) (protocol.Status, error) {
+	return s.ScanAt(ctx, sessionID, token, s.now())
+}
+
+// ScanAt is Scan for a token the camera captured at scannedAt, on the student's clock
+func (s *Student) ScanAt(ctx context.Context, sessionID models.ID, token models.RandomID, scannedAt time.Time) (protocol.Status, error) {
+	err := s.conn.Send(protocol.TypeScan, models.ScanMessage{
+		SessionID:       sessionID,
+		ScannedRandomID: token.ID,
+		ScannedAt:       strconv.FormatInt(scannedAt.UnixMilli(), 10),
+		Location:        s.location,
+	})
+	if err != nil {
+		return protocol.Status{}, err
+	}
+	return s.Next(ctx)
+}
+
+// SetLocation makes later scans report the phone at location, for sessions with a geofence
+func (s *Student) SetLocation(location models.DeviceLocation) {
+	s.location = &location
+}
+
+// Next waits for the next result, such as the teacher's decision after a pending scan
+func (s *Student) Next(ctx context.Context) (protocol.Status, error) {
+	select {
+	case <-ctx.Done():
+		return protocol.Status{}, ctx.Err()
+	case result, ok := <-s.results:
+		if !ok {
+			if s.err == nil {
+				return protocol.Status{}, fmt.Errorf("connection closed")
+			}
+			return protocol.Status{}, s.err
+		}
+		if result.Status == protocol.StatusError {
+			return result, &StatusError{result}
+		}
+		return result, nil
+	}
+}
+
+// Close disconnects the student
+func (s *Student) Close() error {
+	return s.conn.Close()
+}
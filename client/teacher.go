@@ -0,0 +1,296 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+	"github.com/anuragrao04/qr-attendance-backend/protocol"
+)
+
+// SessionParams picks what a new session is for. Set either the offering or the tables
+type SessionParams struct {
+	CourseOfferingID uint
+	ClassroomTables  []string
+	RenderLatency    time.Duration // reported as the time taken to render each probe QR
+}
+
+// Event is anything a session socket pushes besides tokens. It is one of
+// AttendanceUpdate, CommandResult or SessionEvent
+type Event interface {
+	EventType() string
+}
+
+// AttendanceUpdate is the full attendance list, sent after every change
+type AttendanceUpdate struct {
+	protocol.AttendanceUpdate
+}
+
+func (AttendanceUpdate) EventType() string { return protocol.TypeAttendanceUpdate }
+
+// CommandResult is the server's reply to a command, in the order commands were sent
+type CommandResult struct {
+	protocol.Status
+}
+
+func (CommandResult) EventType() string { return protocol.TypeCommandResult }
+
+// SessionEvent is any other event, such as a spot-check update or SESSION_ENDED.
+// Payload holds the event's JSON as sent by the server
+type SessionEvent struct {
+	Type    string
+	Payload json.RawMessage
+}
+
+func (e SessionEvent) EventType() string { return e.Type }
+
+// Session is a teacher's, delegate's or observer's connection to a live session
+type Session struct {
+	SessionID models.ID
+	Students  []models.StudentInASession
+
+	conn   *protocol.Conn
+	events chan Event
+	tokens chan models.RandomID
+
+	tokenMutex  sync.Mutex
+	latestToken models.RandomID
+
+	renderLatency time.Duration // what probes are answered with, 0 for sockets that aren't probed
+
+	err error // why the read loop stopped, set before events is closed
+}
+
+// OpenSession starts a new session as its teacher, answering the render-latency probe
+// on the way. The session lasts until Close
+func OpenSession(ctx context.Context, opts Options, params SessionParams) (*Session, error) {
+	query := url.Values{}
+	if params.CourseOfferingID != 0 {
+		query.Set("offering", strconv.FormatUint(uint64(params.CourseOfferingID), 10))
+	}
+	for _, table := range params.ClassroomTables {
+		query.Add("table", table)
+	}
+
+	conn, err := dial(ctx, opts, "/create-attendance-session", query)
+	if err != nil {
+		return nil, err
+	}
+
+	var probe models.RandomID
+	msgType, err := conn.Receive(&probe)
+	if err == nil && msgType != protocol.TypeLatencyProbe {
+		err = fmt.Errorf("expected %s, got %s", protocol.TypeLatencyProbe, msgType)
+	}
+	if err == nil {
+		err = conn.Send(protocol.TypeLatencyProbeAck, nil)
+	}
+	if err == nil {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-time.After(params.RenderLatency):
+			err = conn.Send(protocol.TypeRenderLatency, protocol.RenderLatency{Message: params.RenderLatency.Milliseconds()})
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("latency probe failed: %w", err)
+	}
+
+	return startSession(conn, params.RenderLatency)
+}
+
+// JoinSession connects to someone else's session through a delegation.
+// What commands go through depends on the delegation's scope
+func JoinSession(ctx context.Context, opts Options, sessionID models.ID) (*Session, error) {
+	return listen(ctx, opts, "/join-attendance-session", sessionID)
+}
+
+// ObserveSession watches any session read-only. Every command is refused
+func ObserveSession(ctx context.Context, opts Options, sessionID models.ID) (*Session, error) {
+	return listen(ctx, opts, "/observe-attendance-session", sessionID)
+}
+
+func listen(ctx context.Context, opts Options, path string, sessionID models.ID) (*Session, error) {
+	conn, err := dial(ctx, opts, path, url.Values{"session": {sessionID.String()}})
+	if err != nil {
+		return nil, err
+	}
+	return startSession(conn, 0)
+}
+
+// startSession waits for the roster, then reads the socket in the background
+func startSession(conn *protocol.Conn, renderLatency time.Duration) (*Session, error) {
+	var raw json.RawMessage
+	msgType, err := conn.Receive(&raw)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	var started protocol.SessionStarted
+	switch msgType {
+	case protocol.TypeSessionStarted:
+		err = json.Unmarshal(raw, &started)
+	case protocol.TypeError:
+		var status protocol.Status
+		if err = json.Unmarshal(raw, &status); err == nil {
+			err = &StatusError{status}
+		}
+	default:
+		err = fmt.Errorf("expected %s, got %s", protocol.TypeSessionStarted, msgType)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	session := &Session{
+		SessionID:     started.SessionID,
+		Students:      started.Students,
+		conn:          conn,
+		events:        make(chan Event, 64),
+		tokens:        make(chan models.RandomID, 1),
+		renderLatency: renderLatency,
+	}
+	go session.read()
+	return session, nil
+}
+
+func (s *Session) read() {
+	defer close(s.events)
+	defer close(s.tokens)
+
+	for {
+		var raw json.RawMessage
+		msgType, err := s.conn.Receive(&raw)
+		if err != nil {
+			s.err = err
+			return
+		}
+
+		switch msgType {
+		case protocol.TypeRandomID, protocol.TypeLatencyProbe:
+			var token models.RandomID
+			if err := json.Unmarshal(raw, &token); err != nil {
+				s.err = err
+				return
+			}
+			if msgType == protocol.TypeLatencyProbe {
+				s.answerProbe()
+			}
+			s.tokenMutex.Lock()
+			s.latestToken = token
+			s.tokenMutex.Unlock()
+
+			// only the newest token matters, drop the one nobody picked up
+			select {
+			case <-s.tokens:
+			default:
+			}
+			s.tokens <- token
+
+		case protocol.TypeAttendanceUpdate:
+			var update AttendanceUpdate
+			if err := json.Unmarshal(raw, &update.AttendanceUpdate); err != nil {
+				s.err = err
+				return
+			}
+			s.events <- update
+
+		case protocol.TypeCommandResult, protocol.TypeError:
+			var result CommandResult
+			if err := json.Unmarshal(raw, &result.Status); err != nil {
+				s.err = err
+				return
+			}
+			s.events <- result
+
+		default:
+			s.events <- SessionEvent{Type: msgType, Payload: raw}
+		}
+	}
+}
+
+// answerProbe acknowledges a probe QR straight away, and reports it rendered once the
+// configured render latency has passed
+func (s *Session) answerProbe() {
+	if err := s.conn.Send(protocol.TypeLatencyProbeAck, nil); err != nil {
+		return
+	}
+	time.AfterFunc(s.renderLatency, func() {
+		s.conn.Send(protocol.TypeRenderLatency, protocol.RenderLatency{Message: s.renderLatency.Milliseconds()})
+	})
+}
+
+// Events delivers everything but tokens. It must be drained, or the connection stalls.
+// It is closed when the connection ends, after which Err says why
+func (s *Session) Events() <-chan Event {
+	return s.events
+}
+
+// Tokens delivers the QR tokens as the server rotates them. Only the newest unread token is kept
+func (s *Session) Tokens() <-chan models.RandomID {
+	return s.tokens
+}
+
+// LatestToken is the QR currently on screen, zero before the first one arrives
+func (s *Session) LatestToken() models.RandomID {
+	s.tokenMutex.Lock()
+	defer s.tokenMutex.Unlock()
+	return s.latestToken
+}
+
+// Err is why the connection ended. Only valid once Events is closed
+func (s *Session) Err() error {
+	return s.err
+}
+
+// Close leaves the session. For the teacher that ends it
+func (s *Session) Close() error {
+	return s.conn.Close()
+}
+
+// Command sends any teacher command. The outcome arrives as a CommandResult event
+func (s *Session) Command(commandType string, command protocol.TeacherCommand) error {
+	return s.conn.Send(commandType, command)
+}
+
+// Toggle flips one student's attendance
+func (s *Session) Toggle(srn string, reasonCode string, comment string) error {
+	return s.Command(protocol.TypeToggleAttendance, protocol.TeacherCommand{SRN: srn, ReasonCode: reasonCode, Comment: comment})
+}
+
+// MarkAll marks the whole roster present or absent
+func (s *Session) MarkAll(present bool, reasonCode string, comment string) error {
+	commandType := protocol.TypeMarkAllAbsent
+	if present {
+		commandType = protocol.TypeMarkAllPresent
+	}
+	return s.Command(commandType, protocol.TeacherCommand{ReasonCode: reasonCode, Comment: comment})
+}
+
+// MarkSRNs sets the attendance of the given students
+func (s *Session) MarkSRNs(srns []string, present bool, reasonCode string, comment string) error {
+	return s.Command(protocol.TypeMarkSRNs, protocol.TeacherCommand{SRNs: srns, IsPresent: present, ReasonCode: reasonCode, Comment: comment})
+}
+
+// ResolveUnrostered approves or rejects a student waiting outside the roster
+func (s *Session) ResolveUnrostered(srn string, approve bool, reasonCode string, comment string) error {
+	commandType := protocol.TypeRejectUnrostered
+	if approve {
+		commandType = protocol.TypeApproveUnrostered
+	}
+	return s.Command(commandType, protocol.TeacherCommand{SRN: srn, ReasonCode: reasonCode, Comment: comment})
+}
+
+// StartSpotCheck asks the present students to scan again within duration
+func (s *Session) StartSpotCheck(duration time.Duration) error {
+	return s.Command(protocol.TypeStartSpotCheck, protocol.TeacherCommand{Duration: int64(duration / time.Second)})
+}
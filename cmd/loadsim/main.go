@@ -0,0 +1,295 @@
+// Command loadsim simulates a classroom scanning at once against a running server.
+// It opens a session as a teacher, lets every student on the roster scan the rotating
+// QR with their own clock skew, network latency and camera delay, then reports how
+// many were accepted, how many were wrongly rejected and how long scans took.
+//
+//	go run ./cmd/loadsim -server http://localhost:6969 -teacher T001 -tables CSE_A -students 300
+//
+// The teacher needs the teacher role and every simulated student the student role.
+// Their session cookies are signed with -secret, the server's SESSION_SECRET.
+// Students are honest: a rejection counts as false if the token was still valid,
+// by the server's own expiry, at the real moment the camera captured it.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/auth"
+	"github.com/anuragrao04/qr-attendance-backend/client"
+	"github.com/anuragrao04/qr-attendance-backend/protocol"
+	"github.com/gorilla/websocket"
+)
+
+// how long past its expiry the server still accepts a token
+const expiryTolerance = 100 * time.Millisecond
+
+type config struct {
+	server      string
+	origin      string
+	teacher     string
+	secret      string
+	tables      string
+	offering    uint
+	students    int
+	spread      time.Duration
+	skew        time.Duration
+	latency     time.Duration
+	cameraDelay time.Duration
+	retries     int
+	timeout     time.Duration
+}
+
+// options connects to the server logged in as srn, over a network with the given one-way latency
+func (cfg config) options(srn string, latency time.Duration) client.Options {
+	opts := client.Options{
+		BaseURL: cfg.server,
+		Origin:  cfg.origin,
+		Session: auth.SignSession([]byte(cfg.secret), srn, time.Now().Add(cfg.timeout+time.Hour)),
+	}
+	if latency > 0 {
+		dialer := *websocket.DefaultDialer
+		dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return latencyConn{Conn: conn, latency: latency}, nil
+		}
+		opts.Dialer = &dialer
+	}
+	return opts
+}
+
+// latencyConn holds back everything a phone sends and receives by its network latency,
+// so the server's clock sync measures it like a real phone's and not just the scans
+type latencyConn struct {
+	net.Conn
+	latency time.Duration
+}
+
+func (c latencyConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		time.Sleep(c.latency)
+	}
+	return n, err
+}
+
+func (c latencyConn) Write(b []byte) (int, error) {
+	time.Sleep(c.latency)
+	return c.Conn.Write(b)
+}
+
+// attempt is one scan as the simulator saw it
+type attempt struct {
+	roundTrip     time.Duration
+	accepted      bool
+	code          string
+	expectedValid bool
+	err           error
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.server, "server", "http://localhost:6969", "server base URL")
+	flag.StringVar(&cfg.origin, "origin", "http://localhost:3000", "Origin header the server accepts")
+	flag.StringVar(&cfg.teacher, "teacher", "", "SRN of the teacher opening the session")
+	flag.StringVar(&cfg.secret, "secret", os.Getenv("SESSION_SECRET"), "SESSION_SECRET of the server, to log the simulated users in")
+	flag.StringVar(&cfg.tables, "tables", "", "comma separated classroom tables, for an ad hoc session")
+	flag.UintVar(&cfg.offering, "offering", 0, "course offering to open the session for")
+	flag.IntVar(&cfg.students, "students", 300, "how many students from the roster scan")
+	flag.DurationVar(&cfg.spread, "spread", time.Second, "window in which all students start scanning")
+	flag.DurationVar(&cfg.skew, "skew", 2*time.Second, "maximum clock skew of a phone, either direction")
+	flag.DurationVar(&cfg.latency, "latency", 50*time.Millisecond, "maximum one-way network latency of a phone")
+	flag.DurationVar(&cfg.cameraDelay, "camera-delay", 150*time.Millisecond, "maximum time from a QR appearing to the camera decoding it")
+	flag.IntVar(&cfg.retries, "retries", 3, "rescans a rejected student makes")
+	flag.DurationVar(&cfg.timeout, "timeout", time.Minute, "give up on the run after this long")
+	flag.Parse()
+
+	if cfg.teacher == "" || cfg.secret == "" || (cfg.tables == "" && cfg.offering == 0) {
+		fmt.Fprintln(os.Stderr, "loadsim: -teacher, -secret and one of -tables or -offering are required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+
+	if err := run(ctx, cfg); err != nil {
+		log.Fatalf("loadsim: %v", err)
+	}
+}
+
+func run(ctx context.Context, cfg config) error {
+	params := client.SessionParams{CourseOfferingID: cfg.offering, RenderLatency: 20 * time.Millisecond}
+	if cfg.tables != "" {
+		params.ClassroomTables = strings.Split(cfg.tables, ",")
+	}
+
+	session, err := client.OpenSession(ctx, cfg.options(cfg.teacher, 0), params)
+	if err != nil {
+		return fmt.Errorf("opening session: %w", err)
+	}
+	defer session.Close()
+	go func() {
+		// the SDK needs its events drained
+		for range session.Events() {
+		}
+	}()
+
+	if len(session.Students) < cfg.students {
+		return fmt.Errorf("roster has %d students, %d asked for", len(session.Students), cfg.students)
+	}
+	log.Printf("Session %d open, %d students scanning", session.SessionID, cfg.students)
+
+	// wait for the QR to start rotating
+	select {
+	case <-session.Tokens():
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	results := make([][]attempt, cfg.students)
+	var wg sync.WaitGroup
+	started := time.Now()
+	for i := 0; i < cfg.students; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = simulateStudent(ctx, cfg, session, session.Students[i].SRN)
+		}(i)
+	}
+	wg.Wait()
+
+	report(results, time.Since(started))
+	return nil
+}
+
+// simulateStudent scans until accepted or out of retries, and returns every attempt
+func simulateStudent(ctx context.Context, cfg config, session *client.Session, srn string) []attempt {
+	skew := randomDuration(-cfg.skew, cfg.skew)
+	latency := randomDuration(0, cfg.latency)
+	time.Sleep(randomDuration(0, cfg.spread))
+
+	student, err := client.ConnectStudentWithClock(ctx,
+		cfg.options(srn, latency),
+		func() time.Time { return time.Now().Add(skew) },
+	)
+	if err != nil {
+		return []attempt{{err: err}}
+	}
+	defer student.Close()
+
+	var attempts []attempt
+	for try := 0; try <= cfg.retries; try++ {
+		token := session.LatestToken()
+		time.Sleep(randomDuration(0, cfg.cameraDelay))
+		capturedAt := time.Now()
+		result, err := student.ScanAt(ctx, session.SessionID, token, capturedAt.Add(skew))
+		a := attempt{
+			roundTrip:     time.Since(capturedAt),
+			expectedValid: capturedAt.UnixMilli() <= token.ExpiredAt+expiryTolerance.Milliseconds(),
+		}
+
+		var statusErr *client.StatusError
+		switch {
+		case err == nil:
+			a.accepted = result.Status == protocol.StatusOK
+			a.code = result.Status
+		case errors.As(err, &statusErr):
+			a.code = statusErr.Code
+		default:
+			a.err = err
+		}
+		attempts = append(attempts, a)
+
+		if a.accepted || a.err != nil {
+			break
+		}
+	}
+	return attempts
+}
+
+func report(results [][]attempt, elapsed time.Duration) {
+	var (
+		accepted, firstTry, failed, transport, falseRejections, scans int
+		roundTrips                                                    []time.Duration
+		codes                                                         = make(map[string]int)
+	)
+
+	for _, attempts := range results {
+		for i, a := range attempts {
+			if a.err != nil {
+				transport++
+				continue
+			}
+			scans++
+			roundTrips = append(roundTrips, a.roundTrip)
+			if a.accepted {
+				accepted++
+				if i == 0 {
+					firstTry++
+				}
+				continue
+			}
+			codes[a.code]++
+			if a.expectedValid {
+				falseRejections++
+			}
+		}
+		if len(attempts) > 0 && !attempts[len(attempts)-1].accepted {
+			failed++
+		}
+	}
+
+	students := len(results)
+	fmt.Printf("students:          %d in %s\n", students, elapsed.Round(time.Millisecond))
+	fmt.Printf("accepted:          %d (%.1f%%), %d on the first scan\n", accepted, percent(accepted, students), firstTry)
+	fmt.Printf("never accepted:    %d\n", failed)
+	fmt.Printf("scans:             %d, %d connection errors\n", scans, transport)
+	fmt.Printf("false rejections:  %d (%.1f%% of scans)\n", falseRejections, percent(falseRejections, scans))
+	for code, count := range codes {
+		fmt.Printf("  %-24s %d\n", code, count)
+	}
+
+	if len(roundTrips) == 0 {
+		return
+	}
+	sort.Slice(roundTrips, func(i, j int) bool { return roundTrips[i] < roundTrips[j] })
+	fmt.Printf("scan round trip:   p50 %s  p90 %s  p99 %s  max %s\n",
+		percentile(roundTrips, 50), percentile(roundTrips, 90), percentile(roundTrips, 99), percentile(roundTrips, 100))
+}
+
+// percentile of a sorted slice, nearest rank
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1].Round(time.Microsecond)
+}
+
+func percent(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(whole)
+}
+
+func randomDuration(low, high time.Duration) time.Duration {
+	if high <= low {
+		return low
+	}
+	return low + time.Duration(rand.Int63n(int64(high-low)))
+}
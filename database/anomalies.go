@@ -0,0 +1,21 @@
+package database
+
+import (
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+// CreateAnomalyFlag stores a flagged student
+func CreateAnomalyFlag(flag *models.AnomalyFlag) error {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	return GORMDB.Create(flag).Error
+}
+
+// GetAnomalyFlags returns every flag raised in a finished session, in the order they were raised
+func GetAnomalyFlags(record models.SessionRecord) ([]models.AnomalyFlag, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var flags []models.AnomalyFlag
+	err := during(GORMDB, record.SessionID, record.StartedAt, record.EndedAt).Order("id").Find(&flags).Error
+	return flags, err
+}
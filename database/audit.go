@@ -0,0 +1,31 @@
+package database
+
+import (
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+// CreateAttendanceAudits appends audit entries. There is intentionally no update or delete counterpart
+func CreateAttendanceAudits(entries []models.AttendanceAudit) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	return GORMDB.Create(&entries).Error
+}
+
+// GetAttendanceAudits returns the change history, oldest first. Zero values act as wildcards
+func GetAttendanceAudits(sessionID models.ID, SRN string) ([]models.AttendanceAudit, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	query := GORMDB.Order("created_at ASC, id ASC")
+	if sessionID != 0 {
+		query = query.Where("session_id = ?", sessionID)
+	}
+	if SRN != "" {
+		query = query.Where("SRN = ?", SRN)
+	}
+	var entries []models.AttendanceAudit
+	err := query.Find(&entries).Error
+	return entries, err
+}
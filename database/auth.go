@@ -18,6 +18,17 @@ func CreateUser(SRN string) (models.User, error) {
 	if err != nil {
 		return user, err
 	}
+
+	// everyone who registers themselves is a student, staff roles are granted by admins
+	var student models.Role
+	err = GORMDB.Where("name = ?", models.RoleStudent).First(&student).Error
+	if err != nil {
+		return user, err
+	}
+	err = GORMDB.Model(&user).Association("Roles").Append(&student)
+	if err != nil {
+		return user, err
+	}
 	return user, nil
 }
 
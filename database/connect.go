@@ -27,10 +27,44 @@ func Connect() {
 }
 
 func ConnectGORM() {
+	if err := OpenGORM("users.db"); err != nil {
+		panic("failed to connect to users database")
+	}
+}
+
+// OpenGORM opens the users database at dsn, migrates it and seeds the built in roles.
+// Tests point it at an in-memory database
+func OpenGORM(dsn string) error {
 	var err error
-	GORMDB, err = gorm.Open(sqlite.Open("users.db"), &gorm.Config{})
+	GORMDB, err = gorm.Open(sqlite.Open(dsn), &gorm.Config{})
 	if err != nil {
-		panic("failed to connect to users database")
+		return err
+	}
+	err = GORMDB.AutoMigrate(
+		&models.User{},
+		&models.Role{},
+		&models.Permission{},
+		&models.SeededGrant{},
+		&models.Delegation{},
+		&models.AttendanceAudit{},
+		&models.ScanAttempt{},
+		&models.TeacherLatencyEstimate{},
+		&models.StudentTimingProfile{},
+		&models.AnomalyFlag{},
+		&models.TimetableSlot{},
+		&models.SlotOccurrence{},
+		&models.Course{},
+		&models.CourseOffering{},
+		&models.CourseOfferingTeacher{},
+		&models.CourseOfferingClassroom{},
+		&models.ClassroomGeofence{},
+		&models.ClassroomNetwork{},
+		&models.SessionRecord{},
+		&models.AttendanceRecord{},
+		&models.SpotCheckRecord{},
+	)
+	if err != nil {
+		return err
 	}
-	GORMDB.AutoMigrate(&models.User{})
+	return SeedRoles()
 }
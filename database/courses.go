@@ -0,0 +1,42 @@
+package database
+
+import (
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+func CreateCourse(course *models.Course) error {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	return GORMDB.Create(course).Error
+}
+
+func GetCourses() ([]models.Course, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var courses []models.Course
+	err := GORMDB.Order("subject_code").Find(&courses).Error
+	return courses, err
+}
+
+// CreateCourseOffering creates the offering along with its teachers and classrooms
+func CreateCourseOffering(offering *models.CourseOffering) error {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	return GORMDB.Omit("Course").Create(offering).Error
+}
+
+func GetCourseOffering(id uint) (models.CourseOffering, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var offering models.CourseOffering
+	err := GORMDB.Preload("Course").Preload("Teachers").Preload("Classrooms").First(&offering, id).Error
+	return offering, err
+}
+
+func GetCourseOfferings() ([]models.CourseOffering, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var offerings []models.CourseOffering
+	err := GORMDB.Preload("Course").Preload("Teachers").Preload("Classrooms").Find(&offerings).Error
+	return offerings, err
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+func CreateDelegation(delegation *models.Delegation) error {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	return GORMDB.Create(delegation).Error
+}
+
+func GetDelegation(id uint) (models.Delegation, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var delegation models.Delegation
+	err := GORMDB.First(&delegation, id).Error
+	return delegation, err
+}
+
+// GetActiveDelegations returns the unexpired delegations given by or to a user
+func GetActiveDelegations(userID string) ([]models.Delegation, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var delegations []models.Delegation
+	err := GORMDB.
+		Where("(delegator_id = ? OR delegate_id = ?) AND expires_at > ?", userID, userID, time.Now()).
+		Order("expires_at").
+		Find(&delegations).Error
+	return delegations, err
+}
+
+// GetDelegationsFor returns the unexpired delegations of a delegate that cover the
+// course offering or the session. Zero values are never matched
+func GetDelegationsFor(delegateID string, courseOfferingID uint, sessionID models.ID) ([]models.Delegation, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var delegations []models.Delegation
+	err := GORMDB.
+		Where("delegate_id = ? AND expires_at > ?", delegateID, time.Now()).
+		Where("(course_offering_id <> 0 AND course_offering_id = ?) OR (session_id <> 0 AND session_id = ?)", courseOfferingID, sessionID).
+		Find(&delegations).Error
+	return delegations, err
+}
+
+// RevokeDelegation soft deletes a delegation so it no longer grants access
+func RevokeDelegation(id uint) error {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	return GORMDB.Delete(&models.Delegation{}, id).Error
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+// SaveClassroomGeofence creates or replaces a classroom's geofence
+func SaveClassroomGeofence(geofence *models.ClassroomGeofence) error {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	return GORMDB.Save(geofence).Error
+}
+
+func GetClassroomGeofences() ([]models.ClassroomGeofence, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var geofences []models.ClassroomGeofence
+	err := GORMDB.Order("classroom_table").Find(&geofences).Error
+	return geofences, err
+}
+
+// GetClassroomGeofence returns the geofence of the first of the classrooms that has one
+func GetClassroomGeofence(classroomTables []string) (models.ClassroomGeofence, bool, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var geofences []models.ClassroomGeofence
+	if err := GORMDB.Where("classroom_table IN ?", classroomTables).Find(&geofences).Error; err != nil {
+		return models.ClassroomGeofence{}, false, err
+	}
+	for _, table := range classroomTables {
+		for _, geofence := range geofences {
+			if geofence.ClassroomTable == table {
+				return geofence, true, nil
+			}
+		}
+	}
+	return models.ClassroomGeofence{}, false, nil
+}
+
+// DeleteClassroomGeofence removes a classroom's geofence, reporting whether it had one
+func DeleteClassroomGeofence(classroomTable string) (bool, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	result := GORMDB.Where("classroom_table = ?", classroomTable).Delete(&models.ClassroomGeofence{})
+	return result.RowsAffected > 0, result.Error
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+// SaveClassroomNetwork creates or replaces a classroom's network restriction
+func SaveClassroomNetwork(network *models.ClassroomNetwork) error {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	return GORMDB.Save(network).Error
+}
+
+func GetClassroomNetworks() ([]models.ClassroomNetwork, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var networks []models.ClassroomNetwork
+	err := GORMDB.Order("classroom_table").Find(&networks).Error
+	return networks, err
+}
+
+// GetClassroomNetwork returns the network restriction of the first of the classrooms that has one
+func GetClassroomNetwork(classroomTables []string) (models.ClassroomNetwork, bool, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var networks []models.ClassroomNetwork
+	if err := GORMDB.Where("classroom_table IN ?", classroomTables).Find(&networks).Error; err != nil {
+		return models.ClassroomNetwork{}, false, err
+	}
+	for _, table := range classroomTables {
+		for _, network := range networks {
+			if network.ClassroomTable == table {
+				return network, true, nil
+			}
+		}
+	}
+	return models.ClassroomNetwork{}, false, nil
+}
+
+// DeleteClassroomNetwork removes a classroom's network restriction, reporting whether it had one
+func DeleteClassroomNetwork(classroomTable string) (bool, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	result := GORMDB.Where("classroom_table = ?", classroomTable).Delete(&models.ClassroomNetwork{})
+	return result.RowsAffected > 0, result.Error
+}
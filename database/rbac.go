@@ -0,0 +1,220 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+	"gorm.io/gorm"
+)
+
+// SeedRoles makes sure every known permission exists and the built in roles hold
+// their default permissions. Each default is granted only once, so permissions added
+// in later versions reach existing roles while admin edits survive restarts. Defaults
+// dropped in later versions are taken back from the roles they were seeded into.
+// Users without any role, registered before roles existed, are made students
+func SeedRoles() error {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+
+	return GORMDB.Transaction(func(tx *gorm.DB) error {
+		for _, name := range models.AllPermissions {
+			if err := tx.FirstOrCreate(&models.Permission{}, models.Permission{Name: name}).Error; err != nil {
+				return err
+			}
+		}
+
+		for roleName, permissionNames := range models.DefaultRolePermissions {
+			var role models.Role
+			if err := tx.FirstOrCreate(&role, models.Role{Name: roleName}).Error; err != nil {
+				return err
+			}
+
+			var seeded []string
+			if err := tx.Model(&models.SeededGrant{}).Where("role = ?", roleName).Pluck("permission", &seeded).Error; err != nil {
+				return err
+			}
+			var missing, dropped []string
+			for _, name := range permissionNames {
+				if !slices.Contains(seeded, name) {
+					missing = append(missing, name)
+				}
+			}
+			for _, name := range seeded {
+				if !slices.Contains(permissionNames, name) {
+					dropped = append(dropped, name)
+				}
+			}
+
+			if len(dropped) > 0 {
+				var permissions []models.Permission
+				if err := tx.Where("name IN ?", dropped).Find(&permissions).Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&role).Association("Permissions").Delete(permissions); err != nil {
+					return err
+				}
+				if err := tx.Where("role = ? AND permission IN ?", roleName, dropped).Delete(&models.SeededGrant{}).Error; err != nil {
+					return err
+				}
+				log.Printf("Took %s back from role %s", strings.Join(dropped, ", "), roleName)
+			}
+			if len(missing) == 0 {
+				continue
+			}
+
+			var permissions []models.Permission
+			if err := tx.Where("name IN ?", missing).Find(&permissions).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&role).Association("Permissions").Append(permissions); err != nil {
+				return err
+			}
+			for _, name := range missing {
+				if err := tx.Create(&models.SeededGrant{Role: roleName, Permission: name}).Error; err != nil {
+					return err
+				}
+			}
+			log.Printf("Seeded role %s with %s", roleName, strings.Join(missing, ", "))
+		}
+
+		var student models.Role
+		if err := tx.Where("name = ?", models.RoleStudent).First(&student).Error; err != nil {
+			return err
+		}
+		return tx.Exec(
+			"INSERT INTO user_roles (user_id, role_id) SELECT id, ? FROM users WHERE deleted_at IS NULL AND id NOT IN (SELECT user_id FROM user_roles)",
+			student.ID,
+		).Error
+	})
+}
+
+// UserHasPermission reports whether any of the user's roles grants the permission
+func UserHasPermission(SRN string, permission string) (bool, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var count int64
+	err := GORMDB.Table("users").
+		Joins("JOIN user_roles ON user_roles.user_id = users.id").
+		Joins("JOIN role_permissions ON role_permissions.role_id = user_roles.role_id").
+		Joins("JOIN permissions ON permissions.id = role_permissions.permission_id").
+		Where("users.SRN = ? AND users.deleted_at IS NULL AND permissions.name = ?", SRN, permission).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func GetRoles() ([]models.Role, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var roles []models.Role
+	err := GORMDB.Preload("Permissions").Order("name").Find(&roles).Error
+	return roles, err
+}
+
+// SaveRole creates the role or replaces the permissions of an existing one
+func SaveRole(name string, permissionNames []string) (models.Role, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+
+	var role models.Role
+	err := GORMDB.Transaction(func(tx *gorm.DB) error {
+		var permissions []models.Permission
+		if err := tx.Where("name IN ?", permissionNames).Find(&permissions).Error; err != nil {
+			return err
+		}
+		if len(permissions) != len(permissionNames) {
+			return errors.New("unknown permission in list")
+		}
+		if err := tx.FirstOrCreate(&role, models.Role{Name: name}).Error; err != nil {
+			return err
+		}
+		role.Permissions = permissions
+		return tx.Model(&role).Association("Permissions").Replace(permissions)
+	})
+	return role, err
+}
+
+// GetUserRoles returns the roles of a user, erroring if the user doesn't exist
+func GetUserRoles(SRN string) ([]models.Role, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var user models.User
+	err := GORMDB.Preload("Roles").Where("SRN = ?", SRN).First(&user).Error
+	return user.Roles, err
+}
+
+// ErrNotRegistered is returned when granting roles to someone without a passkey yet.
+// Creating the account for them would let whoever registers that ID first inherit the roles
+var ErrNotRegistered = errors.New("user hasn't registered a passkey yet")
+
+// registeredUser loads a user that has at least one credential
+func registeredUser(tx *gorm.DB, SRN string) (models.User, error) {
+	var user models.User
+	err := tx.Where("SRN = ?", SRN).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && len(user.Credentials) == 0) {
+		return user, fmt.Errorf("%s: %w", SRN, ErrNotRegistered)
+	}
+	return user, err
+}
+
+// SetUserRoles replaces the roles of a registered user
+func SetUserRoles(SRN string, roleNames []string) ([]models.Role, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+
+	var roles []models.Role
+	err := GORMDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("name IN ?", roleNames).Find(&roles).Error; err != nil {
+			return err
+		}
+		if len(roles) != len(roleNames) {
+			return errors.New("unknown role in list")
+		}
+		user, err := registeredUser(tx, SRN)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&user).Association("Roles").Replace(roles)
+	})
+	return roles, err
+}
+
+// GrantRole adds a role to a registered user
+func GrantRole(SRN string, roleName string) error {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+
+	var role models.Role
+	if err := GORMDB.Where("name = ?", roleName).First(&role).Error; err != nil {
+		return fmt.Errorf("role %s not found: %w", roleName, err)
+	}
+	user, err := registeredUser(GORMDB, SRN)
+	if err != nil {
+		return err
+	}
+	return GORMDB.Model(&user).Association("Roles").Append(&role)
+}
+
+// ResetUnregisteredRoles makes a user without credentials a plain student again, so
+// staff roles granted to an account before anyone held its passkey can't be claimed
+// by registering it
+func ResetUnregisteredRoles(SRN string) error {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+
+	var user models.User
+	if err := GORMDB.Where("SRN = ?", SRN).First(&user).Error; err != nil {
+		return err
+	}
+	if len(user.Credentials) > 0 {
+		return nil
+	}
+	var student models.Role
+	if err := GORMDB.Where("name = ?", models.RoleStudent).First(&student).Error; err != nil {
+		return err
+	}
+	return GORMDB.Model(&user).Association("Roles").Replace(&student)
+}
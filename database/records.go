@@ -0,0 +1,95 @@
+package database
+
+import (
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+	"gorm.io/gorm"
+)
+
+// SaveSessionRecord persists a finished session along with every student's final status and its spot-checks
+func SaveSessionRecord(record *models.SessionRecord) error {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	return GORMDB.Create(record).Error
+}
+
+func GetSessionRecord(id uint) (models.SessionRecord, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var record models.SessionRecord
+	err := GORMDB.Preload("Records").Preload("SpotChecks").First(&record, id).Error
+	return record, err
+}
+
+// GetSessionRecords lists the finished sessions of a course offering, without the per-student records
+func GetSessionRecords(courseOfferingID uint) ([]models.SessionRecord, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var records []models.SessionRecord
+	err := GORMDB.Where("course_offering_id = ?", courseOfferingID).Order("started_at").Find(&records).Error
+	return records, err
+}
+
+// GetCourseOfferingSummary returns every student's attendance in a course offering
+func GetCourseOfferingSummary(courseOfferingID uint) ([]models.AttendanceSummary, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var summaries []models.AttendanceSummary
+	err := GORMDB.Model(&models.AttendanceRecord{}).
+		Select("course_offering_id, srn, MAX(name) AS name, SUM(is_present) AS attended, COUNT(*) AS total").
+		Where("course_offering_id = ?", courseOfferingID).
+		Group("course_offering_id, srn").
+		Order("srn").
+		Scan(&summaries).Error
+	fillPercentages(summaries)
+	return summaries, err
+}
+
+// GetStudentSummary returns a student's attendance in every course offering they have records in
+func GetStudentSummary(SRN string) ([]models.AttendanceSummary, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var summaries []models.AttendanceSummary
+	err := GORMDB.Table("attendance_records AS ar").
+		Select("ar.course_offering_id, courses.subject_code, ar.srn, MAX(ar.name) AS name, SUM(ar.is_present) AS attended, COUNT(*) AS total").
+		Joins("JOIN course_offerings ON course_offerings.id = ar.course_offering_id").
+		Joins("JOIN courses ON courses.id = course_offerings.course_id").
+		Where("ar.srn = ?", SRN).
+		Group("ar.course_offering_id, courses.subject_code, ar.srn").
+		Order("courses.subject_code").
+		Scan(&summaries).Error
+	fillPercentages(summaries)
+	return summaries, err
+}
+
+// GetStudentHistory returns every persisted record of a student, newest first
+func GetStudentHistory(SRN string) ([]models.AttendanceRecord, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var records []models.AttendanceRecord
+	err := GORMDB.Where("SRN = ?", SRN).Order("id DESC").Find(&records).Error
+	return records, err
+}
+
+func fillPercentages(summaries []models.AttendanceSummary) {
+	for i := range summaries {
+		if summaries[i].Total > 0 {
+			summaries[i].Percentage = float64(summaries[i].Attended) * 100 / float64(summaries[i].Total)
+		}
+	}
+}
+
+// during narrows a query to rows of sessionID created between from and to, both included.
+// Session IDs come back once a session ends, so a finished session's rows are told apart
+// by when it ran. Zero bounds are left open
+func during(query *gorm.DB, sessionID models.ID, from, to time.Time) *gorm.DB {
+	query = query.Where("session_id = ?", sessionID)
+	if !from.IsZero() {
+		query = query.Where("created_at >= ?", from)
+	}
+	if !to.IsZero() {
+		query = query.Where("created_at <= ?", to)
+	}
+	return query
+}
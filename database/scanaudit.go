@@ -0,0 +1,94 @@
+package database
+
+import (
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+// ScanAttemptFilter narrows a scan attempt query. Zero values act as wildcards
+type ScanAttemptFilter struct {
+	SessionID models.ID             // every session that drew the ID
+	Record    *models.SessionRecord // one finished session, while it ran
+	SRN       string
+	Outcome   string
+	From      time.Time
+	To        time.Time
+	Limit     int
+}
+
+// CreateScanAttempts stores a batch of scan attempts
+func CreateScanAttempts(attempts []models.ScanAttempt) error {
+	if len(attempts) == 0 {
+		return nil
+	}
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	return GORMDB.Create(&attempts).Error
+}
+
+// GetScanAttempts returns matching scan attempts, newest first
+func GetScanAttempts(filter ScanAttemptFilter) ([]models.ScanAttempt, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	query := GORMDB.Order("created_at DESC, id DESC")
+	if filter.Record != nil {
+		query = during(query, filter.Record.SessionID, filter.Record.StartedAt, filter.Record.EndedAt)
+	} else if filter.SessionID != 0 {
+		query = query.Where("session_id = ?", filter.SessionID)
+	}
+	if filter.SRN != "" {
+		query = query.Where("SRN = ?", filter.SRN)
+	}
+	if filter.Outcome != "" {
+		query = query.Where("outcome = ?", filter.Outcome)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	var attempts []models.ScanAttempt
+	err := query.Find(&attempts).Error
+	return attempts, err
+}
+
+// PruneScanAttempts deletes scan attempts older than the cutoff and returns how many went
+func PruneScanAttempts(before time.Time) (int64, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	result := GORMDB.Where("created_at < ?", before).Delete(&models.ScanAttempt{})
+	return result.RowsAffected, result.Error
+}
+
+// CreateTeacherLatencyEstimates stores a batch of teacher latency probes
+func CreateTeacherLatencyEstimates(estimates []models.TeacherLatencyEstimate) error {
+	if len(estimates) == 0 {
+		return nil
+	}
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	return GORMDB.Create(&estimates).Error
+}
+
+// GetTeacherLatencyEstimates returns the teacher latency probes of sessionID taken between
+// from and to, oldest first. Zero bounds are left open
+func GetTeacherLatencyEstimates(sessionID models.ID, from, to time.Time) ([]models.TeacherLatencyEstimate, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var estimates []models.TeacherLatencyEstimate
+	err := during(GORMDB, sessionID, from, to).Order("created_at, probe").Find(&estimates).Error
+	return estimates, err
+}
+
+// PruneTeacherLatencyEstimates deletes teacher latency probes older than the cutoff and returns how many went
+func PruneTeacherLatencyEstimates(before time.Time) (int64, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	result := GORMDB.Where("created_at < ?", before).Delete(&models.TeacherLatencyEstimate{})
+	return result.RowsAffected, result.Error
+}
@@ -31,6 +31,7 @@ func GetStudentsInAClassroom(studentTableName string) (students []models.Student
 			// god knows what happened to their SRN, a few people in nursing are like this
 			continue
 		}
+		s.Section = studentTableName
 		s.IsPresent = false // Initialize to false
 		students = append(students, s)
 	}
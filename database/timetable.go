@@ -0,0 +1,103 @@
+package database
+
+import (
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func CreateTimetableSlot(slot *models.TimetableSlot) error {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	return GORMDB.Create(slot).Error
+}
+
+func GetTimetableSlots() ([]models.TimetableSlot, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var slots []models.TimetableSlot
+	err := GORMDB.Order("weekday, start_time").Find(&slots).Error
+	return slots, err
+}
+
+func DeleteTimetableSlot(id uint) error {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	return GORMDB.Delete(&models.TimetableSlot{}, id).Error
+}
+
+// FindTimetableSlot returns the slot of one of teacher's classrooms that is running at the given
+// time, and the date it falls on. A session opened up to grace before the slot starts still counts,
+// even when that is before midnight. Slots without a teacher match anyone's session
+func FindTimetableSlot(teacher string, classroomTables []string, at time.Time, grace time.Duration) (models.TimetableSlot, time.Time, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	tomorrow := at.AddDate(0, 0, 1)
+	var slots []models.TimetableSlot
+	err := GORMDB.
+		Where("classroom_table IN ?", classroomTables).
+		Where("teacher = ? OR teacher = ''", teacher).
+		Where("weekday IN ?", []time.Weekday{at.Weekday(), tomorrow.Weekday()}).
+		Order("start_time").
+		Find(&slots).Error
+	if err != nil {
+		return models.TimetableSlot{}, time.Time{}, err
+	}
+
+	// minutes since midnight of at's day, so tomorrow's slots start after 24:00
+	now := at.Hour()*60 + at.Minute()
+	opensBy := now + int(grace/time.Minute)
+	for days, day := range []time.Time{at, tomorrow} {
+		for _, slot := range slots {
+			if slot.Weekday != day.Weekday() {
+				continue
+			}
+			start, end := days*24*60+minutesOfDay(slot.StartTime), days*24*60+minutesOfDay(slot.EndTime)
+			if start <= opensBy && end >= now {
+				return slot, day, nil
+			}
+		}
+	}
+	return models.TimetableSlot{}, time.Time{}, gorm.ErrRecordNotFound
+}
+
+// minutesOfDay turns a "15:04" time into minutes since midnight
+func minutesOfDay(clock string) int {
+	parsed, _ := time.Parse("15:04", clock)
+	return parsed.Hour()*60 + parsed.Minute()
+}
+
+// RecordSlotOccurrence stores a held or missed slot. The first record for a slot and date wins
+func RecordSlotOccurrence(occurrence models.SlotOccurrence) error {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	return GORMDB.Clauses(clause.OnConflict{DoNothing: true}).Create(&occurrence).Error
+}
+
+// GetUnrecordedSlots returns the slots on date that ended before the given "15:04" time
+// without any session having been held for them. Slots added to the timetable that day are skipped
+func GetUnrecordedSlots(date time.Time, endedBefore string) ([]models.TimetableSlot, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	dayStart := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	var slots []models.TimetableSlot
+	err := GORMDB.
+		Where("weekday = ? AND end_time < ? AND created_at < ?", date.Weekday(), endedBefore, dayStart).
+		Where("id NOT IN (?)", GORMDB.Model(&models.SlotOccurrence{}).Select("slot_id").Where("date = ?", date.Format("2006-01-02"))).
+		Find(&slots).Error
+	return slots, err
+}
+
+// GetMissedSlots returns the missed slot occurrences between two "2006-01-02" dates, inclusive
+func GetMissedSlots(from, to string) ([]models.SlotOccurrence, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	var occurrences []models.SlotOccurrence
+	err := GORMDB.Preload("Slot", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		Where("held = ? AND date >= ? AND date <= ?", false, from, to).
+		Order("date, slot_id").
+		Find(&occurrences).Error
+	return occurrences, err
+}
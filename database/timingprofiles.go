@@ -0,0 +1,41 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+	"gorm.io/gorm"
+)
+
+// GetStudentTimingProfile returns a student's timing profile, empty if they have none yet
+func GetStudentTimingProfile(SRN string) (models.StudentTimingProfile, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	profile := models.StudentTimingProfile{SRN: SRN}
+	err := GORMDB.Where("SRN = ?", SRN).First(&profile).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return profile, nil
+	}
+	return profile, err
+}
+
+// SaveStudentTimingProfile creates or replaces a student's timing profile
+func SaveStudentTimingProfile(profile *models.StudentTimingProfile) error {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	return GORMDB.Save(profile).Error
+}
+
+// GetStudentTimingProfiles lists timing profiles, the most slack first. With onlyExtra,
+// only students who get extra tolerance are listed
+func GetStudentTimingProfiles(onlyExtra bool) ([]models.StudentTimingProfile, error) {
+	GORMDBMutex.Lock()
+	defer GORMDBMutex.Unlock()
+	query := GORMDB.Order("extra_tolerance DESC, SRN")
+	if onlyExtra {
+		query = query.Where("extra_tolerance > 0")
+	}
+	var profiles []models.StudentTimingProfile
+	err := query.Find(&profiles).Error
+	return profiles, err
+}
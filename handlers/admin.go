@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/auth"
+	"github.com/anuragrao04/qr-attendance-backend/database"
+	"github.com/anuragrao04/qr-attendance-backend/models"
+	"github.com/anuragrao04/qr-attendance-backend/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// GetAttendanceAudit returns the manual change history for a student and/or a session
+func GetAttendanceAudit(c *gin.Context) {
+	SRN := c.Query("srn")
+	var sessionID models.ID
+	if rawSessionID := c.Query("sessionID"); rawSessionID != "" {
+		parsed, err := models.ParseID(rawSessionID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sessionID"})
+			return
+		}
+		sessionID = parsed
+	}
+
+	if SRN == "" && sessionID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "srn or sessionID is required"})
+		return
+	}
+
+	entries, err := database.GetAttendanceAudits(sessionID, SRN)
+	if err != nil {
+		log.Printf("Failed to fetch attendance audit: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// GetScanAttempts queries the scan audit. Every filter is optional; from and to are RFC 3339.
+// Session IDs are reused, so pass record for one finished session rather than its sessionID.
+// Filtering by session adds the session's teacher latency probes
+func GetScanAttempts(c *gin.Context) {
+	filter := database.ScanAttemptFilter{
+		SRN:     c.Query("srn"),
+		Outcome: c.Query("outcome"),
+		Limit:   maxScanAttempts,
+	}
+	if rawSessionID := c.Query("sessionID"); rawSessionID != "" {
+		parsed, err := models.ParseID(rawSessionID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sessionID"})
+			return
+		}
+		filter.SessionID = parsed
+	}
+	if rawRecordID := c.Query("record"); rawRecordID != "" {
+		recordID, err := strconv.ParseUint(rawRecordID, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record"})
+			return
+		}
+		record, err := database.GetSessionRecord(uint(recordID))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session record not found"})
+			return
+		}
+		filter.Record = &record
+		filter.SessionID = record.SessionID
+	}
+	for param, bound := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if raw := c.Query(param); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param + ", expected RFC 3339"})
+				return
+			}
+			*bound = parsed
+		}
+	}
+	if rawLimit := c.Query("limit"); rawLimit != "" {
+		limit, err := strconv.Atoi(rawLimit)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		filter.Limit = min(limit, maxScanAttempts)
+	}
+
+	attempts, err := database.GetScanAttempts(filter)
+	if err != nil {
+		log.Printf("Failed to fetch scan attempts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if filter.SessionID == 0 {
+		c.JSON(http.StatusOK, gin.H{"attempts": attempts})
+		return
+	}
+
+	// for one session, also show how its teacher latency estimate moved
+	var from, to time.Time
+	if filter.Record != nil {
+		from, to = filter.Record.StartedAt, filter.Record.EndedAt
+	}
+	teacherLatency, err := database.GetTeacherLatencyEstimates(filter.SessionID, from, to)
+	if err != nil {
+		log.Printf("Failed to fetch teacher latency of session %d: %v", filter.SessionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"attempts": attempts, "teacherLatency": teacherLatency})
+}
+
+// the most scan attempts returned by one query
+const maxScanAttempts = 1000
+
+// GetTimingProfiles shows who is getting extra slack on their scans. Pass srn for one
+// student's profile, or all=true for every profile
+func GetTimingProfiles(c *gin.Context) {
+	if SRN := c.Query("srn"); SRN != "" {
+		profile, err := database.GetStudentTimingProfile(SRN)
+		if err != nil {
+			log.Printf("Failed to fetch the timing profile of %s: %v", SRN, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"profile": profile, "maxExtraTolerance": sessions.MaxExtraTolerance})
+		return
+	}
+
+	profiles, err := database.GetStudentTimingProfiles(c.Query("all") != "true")
+	if err != nil {
+		log.Printf("Failed to fetch timing profiles: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"profiles": profiles, "maxExtraTolerance": sessions.MaxExtraTolerance})
+}
+
+// GetLiveSessions lists every live session with its attendance and scan health
+func GetLiveSessions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions.ListSessions()})
+}
+
+// EndLiveSession force-ends a session, persisting its final state and closing the teacher's socket
+func EndLiveSession(c *gin.Context) {
+	sessionID, err := models.ParseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	if _, err := sessions.SessionOwner(sessionID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := sessions.ForceEndSession(sessionID, auth.Identity(c)); errors.Is(err, sessions.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		log.Printf("Failed to force-end session %d: %v", sessionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "success"})
+}
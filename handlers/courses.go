@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/anuragrao04/qr-attendance-backend/database"
+	"github.com/anuragrao04/qr-attendance-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+func CreateCourse(c *gin.Context) {
+	var course models.Course
+	if err := c.ShouldBindJSON(&course); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if course.SubjectCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "subjectCode is required"})
+		return
+	}
+
+	course.ID = 0
+	if err := database.CreateCourse(&course); err != nil {
+		log.Printf("Failed to create course: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, course)
+}
+
+func GetCourses(c *gin.Context) {
+	courses, err := database.GetCourses()
+	if err != nil {
+		log.Printf("Failed to fetch courses: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"courses": courses})
+}
+
+func CreateCourseOffering(c *gin.Context) {
+	var offering models.CourseOffering
+	if err := c.ShouldBindJSON(&offering); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if offering.CourseID == 0 || len(offering.Classrooms) == 0 || len(offering.Teachers) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "courseID, at least one teacher and at least one classroom are required"})
+		return
+	}
+
+	offering.ID = 0
+	if err := database.CreateCourseOffering(&offering); err != nil {
+		log.Printf("Failed to create course offering: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := database.GetCourseOffering(offering.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, created)
+}
+
+func GetCourseOfferings(c *gin.Context) {
+	offerings, err := database.GetCourseOfferings()
+	if err != nil {
+		log.Printf("Failed to fetch course offerings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"offerings": offerings})
+}
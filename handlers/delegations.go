@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/auth"
+	"github.com/anuragrao04/qr-attendance-backend/database"
+	"github.com/anuragrao04/qr-attendance-backend/models"
+	"github.com/anuragrao04/qr-attendance-backend/protocol"
+	"github.com/anuragrao04/qr-attendance-backend/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// delegations can't outlive a semester
+const maxDelegationLength = 180 * 24 * time.Hour
+
+// CreateDelegation hands a course offering or a live session to a TA until expiresAt
+func CreateDelegation(c *gin.Context) {
+	var delegation models.Delegation
+	if err := c.ShouldBindJSON(&delegation); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	identity := auth.Identity(c)
+	delegation.ID = 0
+	delegation.DelegatorID = identity
+
+	if delegation.DelegateID == "" || delegation.DelegateID == identity {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "delegateID must be someone else"})
+		return
+	}
+	if !sessions.ValidDelegationScope(delegation.Scope) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope must be view, toggle or full"})
+		return
+	}
+	if (delegation.CourseOfferingID == 0) == (delegation.SessionID == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of courseOfferingID and sessionID is required"})
+		return
+	}
+	if !delegation.ExpiresAt.After(time.Now()) || time.Until(delegation.ExpiresAt) > maxDelegationLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiresAt must be in the future and within 180 days"})
+		return
+	}
+
+	manageAny := auth.HasPermission(c, models.PermManageAnySession)
+	if delegation.CourseOfferingID != 0 {
+		offering, err := database.GetCourseOffering(delegation.CourseOfferingID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Course offering not found"})
+			return
+		}
+		if !offering.HasTeacher(identity) && !manageAny {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You don't teach this course offering"})
+			return
+		}
+	} else {
+		owner, err := sessions.SessionOwner(delegation.SessionID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+		if owner != identity && !manageAny {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You don't own this session"})
+			return
+		}
+	}
+
+	if err := database.CreateDelegation(&delegation); err != nil {
+		log.Printf("Failed to create delegation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("%s delegated %s access to %s", identity, delegation.Scope, delegation.DelegateID)
+	sessions.DelegationsChanged(delegation.DelegateID)
+	c.JSON(http.StatusOK, delegation)
+}
+
+// GetDelegations lists the active delegations the caller gave or received
+func GetDelegations(c *gin.Context) {
+	delegations, err := database.GetActiveDelegations(auth.Identity(c))
+	if err != nil {
+		log.Printf("Failed to fetch delegations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"delegations": delegations})
+}
+
+func RevokeDelegation(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delegation ID"})
+		return
+	}
+
+	delegation, err := database.GetDelegation(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delegation not found"})
+		return
+	}
+	if delegation.DelegatorID != auth.Identity(c) && !auth.HasPermission(c, models.PermManageAnySession) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You didn't create this delegation"})
+		return
+	}
+
+	if err := database.RevokeDelegation(delegation.ID); err != nil {
+		log.Printf("Failed to revoke delegation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sessions.DelegationsChanged(delegation.DelegateID)
+	c.JSON(http.StatusOK, gin.H{"message": "success"})
+}
+
+// JoinSession attaches a delegate to someone else's live session. They get the same
+// attendance snapshot and updates as the teacher, but not the rotating random IDs,
+// and can only send the commands their delegation scope allows
+func JoinSession(c *gin.Context) {
+	sessionID, err := models.ParseID(c.Query("session"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	identity := auth.Identity(c)
+	access, err := sessions.SessionAccess(identity, sessionID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if access == sessions.AccessNone {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You have no delegation for this session"})
+		return
+	}
+
+	streamSessionToListener(c, sessionID, access, identity, true)
+}
+
+// streamSessionToListener upgrades the connection and streams a live session to someone other than its owner.
+// If delegated, the access is checked against the identity's delegations again for every command
+func streamSessionToListener(c *gin.Context, sessionID models.ID, access sessions.Access, identity string, delegated bool) {
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade to WebSocket: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to establish WebSocket connection"})
+		return
+	}
+	conn := protocol.NewConn(ws)
+	defer conn.Close()
+
+	attendanceEvents := sessions.RegisterForAttendanceChanges(sessionID)
+	sessionEvents := sessions.RegisterForSessionEvents(sessionID)
+	defer func() {
+		sessions.UnregisterFromAttendanceChanges(sessionID, attendanceEvents)
+		sessions.UnregisterFromSessionEvents(sessionID, sessionEvents)
+		log.Printf("%s left session %d", identity, sessionID)
+	}()
+
+	absentees, presentees, err := sessions.GetAttendanceList(sessionID)
+	if err != nil {
+		conn.Send(protocol.TypeError, protocol.Status{Status: protocol.StatusError, Message: err.Error()})
+		return
+	}
+	log.Printf("%s joined session %d with access %d", identity, sessionID, access)
+
+	err = conn.Send(protocol.TypeSessionStarted, protocol.SessionStarted{
+		SessionID: sessionID,
+		Students:  append(append([]models.StudentInASession{}, absentees...), presentees...),
+	})
+	if err == nil {
+		err = writeAttendanceUpdate(conn, absentees, presentees)
+	}
+	if err != nil {
+		log.Printf("Failed to send session snapshot: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	currentAccess := func() sessions.Access { return access }
+	if delegated {
+		go watchDelegatedAccess(ctx, cancel, sessionID, identity)
+		currentAccess = func() sessions.Access {
+			access, err := sessions.SessionAccess(identity, sessionID)
+			if err != nil {
+				return sessions.AccessNone
+			}
+			return access
+		}
+	}
+
+	go readTeacherCommands(conn, cancel, sessionID, currentAccess, identity, nil)
+	streamSessionEvents(ctx, conn, sessionID, attendanceEvents, sessionEvents)
+}
+
+// watchDelegatedAccess works out a delegate's access again whenever their delegations
+// change or one of them expires, and cancels the connection once none is left
+func watchDelegatedAccess(ctx context.Context, cancel context.CancelFunc, sessionID models.ID, identity string) {
+	changed := sessions.WatchDelegations(identity)
+	defer sessions.StopWatchingDelegations(identity, changed)
+
+	for {
+		access, until, err := sessions.SessionAccessUntil(identity, sessionID)
+		if err != nil || access == sessions.AccessNone {
+			log.Printf("%s lost access to session %d", identity, sessionID)
+			cancel()
+			return
+		}
+
+		var expired <-chan time.Time
+		if !until.IsZero() {
+			expired = time.After(time.Until(until))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		case <-expired:
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/anuragrao04/qr-attendance-backend/database"
+	"github.com/anuragrao04/qr-attendance-backend/models"
+	"github.com/anuragrao04/qr-attendance-backend/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+func GetClassroomGeofences(c *gin.Context) {
+	geofences, err := database.GetClassroomGeofences()
+	if err != nil {
+		log.Printf("Failed to fetch classroom geofences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"geofences": geofences})
+}
+
+// SaveClassroomGeofence sets where a classroom's sessions are expected to be scanned from.
+// It applies to sessions opened after the change
+func SaveClassroomGeofence(c *gin.Context) {
+	var geofence models.Geofence
+	if err := c.ShouldBindJSON(&geofence); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := sessions.ValidateGeofence(&geofence); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	classroomGeofence := models.ClassroomGeofence{ClassroomTable: c.Param("table"), Geofence: geofence}
+	if err := database.SaveClassroomGeofence(&classroomGeofence); err != nil {
+		log.Printf("Failed to save geofence of %s: %v", classroomGeofence.ClassroomTable, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, classroomGeofence)
+}
+
+func DeleteClassroomGeofence(c *gin.Context) {
+	deleted, err := database.DeleteClassroomGeofence(c.Param("table"))
+	if err != nil {
+		log.Printf("Failed to delete geofence of %s: %v", c.Param("table"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Classroom has no geofence"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "success"})
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/anuragrao04/qr-attendance-backend/database"
+	"github.com/anuragrao04/qr-attendance-backend/models"
+	"github.com/anuragrao04/qr-attendance-backend/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+func GetClassroomNetworks(c *gin.Context) {
+	networks, err := database.GetClassroomNetworks()
+	if err != nil {
+		log.Printf("Failed to fetch classroom networks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"networks": networks})
+}
+
+// SaveClassroomNetwork sets the client networks a classroom's sessions may be scanned from.
+// It applies to sessions opened after the change
+func SaveClassroomNetwork(c *gin.Context) {
+	var restriction models.NetworkRestriction
+	if err := c.ShouldBindJSON(&restriction); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := sessions.ValidateNetworkRestriction(&restriction); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	classroomNetwork := models.ClassroomNetwork{ClassroomTable: c.Param("table"), NetworkRestriction: restriction}
+	if err := database.SaveClassroomNetwork(&classroomNetwork); err != nil {
+		log.Printf("Failed to save networks of %s: %v", classroomNetwork.ClassroomTable, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, classroomNetwork)
+}
+
+func DeleteClassroomNetwork(c *gin.Context) {
+	deleted, err := database.DeleteClassroomNetwork(c.Param("table"))
+	if err != nil {
+		log.Printf("Failed to delete networks of %s: %v", c.Param("table"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Classroom has no network restriction"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "success"})
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/anuragrao04/qr-attendance-backend/auth"
+	"github.com/anuragrao04/qr-attendance-backend/models"
+	"github.com/anuragrao04/qr-attendance-backend/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// ObserveSession lets HODs and admins watch any live session; TAs join with a view delegation
+// instead. It sends the same snapshot and attendance updates the teacher gets, never the
+// random IDs, and refuses every command
+func ObserveSession(c *gin.Context) {
+	sessionID, err := models.ParseID(c.Query("session"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	if _, err := sessions.SessionOwner(sessionID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	streamSessionToListener(c, sessionID, sessions.AccessView, auth.Identity(c), false)
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/anuragrao04/qr-attendance-backend/anomaly"
+	"github.com/anuragrao04/qr-attendance-backend/auth"
+	"github.com/anuragrao04/qr-attendance-backend/database"
+	"github.com/gin-gonic/gin"
+)
+
+// GetCourseOfferingReport returns the finished sessions of an offering and every student's percentage in it
+func GetCourseOfferingReport(c *gin.Context) {
+	offeringID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid course offering ID"})
+		return
+	}
+
+	offering, err := database.GetCourseOffering(uint(offeringID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Course offering not found"})
+		return
+	}
+
+	records, err := database.GetSessionRecords(offering.ID)
+	if err != nil {
+		log.Printf("Failed to fetch session records: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	summaries, err := database.GetCourseOfferingSummary(offering.ID)
+	if err != nil {
+		log.Printf("Failed to fetch attendance summary: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"offering": offering, "sessions": records, "students": summaries})
+}
+
+// GetStudentReport returns a student's attendance per course offering, and their full history
+func GetStudentReport(c *gin.Context) {
+	writeStudentReport(c, c.Param("srn"))
+}
+
+// GetOwnReport is GetStudentReport for the signed in student
+func GetOwnReport(c *gin.Context) {
+	writeStudentReport(c, auth.Identity(c))
+}
+
+func writeStudentReport(c *gin.Context, SRN string) {
+	summaries, err := database.GetStudentSummary(SRN)
+	if err != nil {
+		log.Printf("Failed to fetch student summary: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	history, err := database.GetStudentHistory(SRN)
+	if err != nil {
+		log.Printf("Failed to fetch student history: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"SRN": SRN, "courses": summaries, "history": history})
+}
+
+// ExportSessionRecord writes a finished session as CSV. Pass section to get a
+// single classroom's share of a combined session
+func ExportSessionRecord(c *gin.Context) {
+	recordID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session record ID"})
+		return
+	}
+
+	record, err := database.GetSessionRecord(uint(recordID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session record not found"})
+		return
+	}
+
+	section := c.Query("section")
+	filename := fmt.Sprintf("session-%d.csv", record.ID)
+	if section != "" {
+		filename = fmt.Sprintf("session-%d-%s.csv", record.ID, section)
+	}
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"SRN", "PRN", "Name", "Section", "Present", "Guest"})
+	for _, student := range record.Records {
+		if section != "" && student.Section != section {
+			continue
+		}
+		writer.Write([]string{
+			student.SRN,
+			student.PRN,
+			student.Name,
+			student.Section,
+			strconv.FormatBool(student.IsPresent),
+			strconv.FormatBool(student.IsGuest),
+		})
+	}
+	writer.Flush()
+}
+
+// GetSessionAnomalies lists the students flagged as likely proxies in a finished session,
+// the most suspicious first, along with every flag raised
+func GetSessionAnomalies(c *gin.Context) {
+	recordID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session record ID"})
+		return
+	}
+
+	record, err := database.GetSessionRecord(uint(recordID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session record not found"})
+		return
+	}
+
+	flags, err := database.GetAnomalyFlags(record)
+	if err != nil {
+		log.Printf("Failed to fetch anomaly flags: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	record.Records = nil
+	record.SpotChecks = nil
+	c.JSON(http.StatusOK, gin.H{"session": record, "students": anomaly.Summarize(flags), "flags": flags})
+}
+
+// GetSessionSpotChecks lists the spot-checks of a finished session, with who re-verified and who was flagged
+func GetSessionSpotChecks(c *gin.Context) {
+	recordID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session record ID"})
+		return
+	}
+
+	record, err := database.GetSessionRecord(uint(recordID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session record not found"})
+		return
+	}
+
+	spotChecks := record.SpotChecks
+	record.Records = nil
+	record.SpotChecks = nil
+	c.JSON(http.StatusOK, gin.H{"session": record, "spotChecks": spotChecks})
+}
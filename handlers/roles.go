@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/anuragrao04/qr-attendance-backend/database"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GetRoles(c *gin.Context) {
+	roles, err := database.GetRoles()
+	if err != nil {
+		log.Printf("Failed to fetch roles: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// SaveRole creates a role or replaces its permissions
+func SaveRole(c *gin.Context) {
+	var body struct {
+		Permissions []string `json:"permissions"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, err := database.SaveRole(c.Param("name"), body.Permissions)
+	if err != nil {
+		log.Printf("Failed to save role: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+func GetUserRoles(c *gin.Context) {
+	roles, err := database.GetUserRoles(c.Param("srn"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// SetUserRoles replaces a user's roles. Staff have to register their passkey first
+func SetUserRoles(c *gin.Context) {
+	var body struct {
+		Roles []string `json:"roles"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	roles, err := database.SetUserRoles(c.Param("srn"), body.Roles)
+	if errors.Is(err, database.ErrNotRegistered) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to set user roles: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
@@ -1,88 +1,280 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/anuragrao04/qr-attendance-backend/auth"
 	"github.com/anuragrao04/qr-attendance-backend/models"
+	"github.com/anuragrao04/qr-attendance-backend/protocol"
 	"github.com/anuragrao04/qr-attendance-backend/sessions"
 	"github.com/gin-gonic/gin"
 )
 
 func StudentScan(c *gin.Context) {
 	// Upgrade HTTP connection to WebSocket
-	SRN, err := c.Cookie("SRN")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "SRN cookie not found"})
-		return
-	}
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	SRN := auth.Identity(c)
+	clientIP := c.ClientIP()
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Printf("Failed to upgrade to WebSocket: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to establish WebSocket connection"})
 		return
 	}
+	conn := protocol.NewConn(ws)
 	defer conn.Close()
+	connectedAt := sessions.Now().UnixMilli()
 
-	serverBeforeTime := time.Now().UnixMilli()
-
-	// Receive initial timestamp from the client for clock drift calculation
-	var initMessage struct {
-		ClientTime string `json:"clientTime"` // Unix timestamp in milliseconds
+	// Measure the student's clock drift and latency
+	var clockSync *sessions.ClockSync
+	var timing sessions.ScanContext
+	if conn.Version() >= protocol.Version4 {
+		clockSync = &sessions.ClockSync{}
+		err = syncStudentClock(conn, clockSync)
+		timing = clockSync.Estimate()
+	} else {
+		timing, err = legacyClockSync(conn)
 	}
-
-	err = conn.ReadJSON(&initMessage)
-
-	serverTime := time.Now().UnixMilli()
-
-	studentLatency := serverTime - serverBeforeTime
-
 	if err != nil {
-		log.Printf("Failed to read initial client message: %v", err)
-		conn.WriteJSON(gin.H{"status": "error", "message": "Failed to read initial data"})
+		log.Printf("Failed to sync clock with %s: %v", SRN, err)
+		writeScanError(conn, sessions.ErrInvalidMessage)
 		return
 	}
 
-	// Calculate clock drift
-	int64ClientTime, _ := strconv.ParseInt(initMessage.ClientTime, 10, 64)
-	clockDrift := serverTime - int64ClientTime // Positive means client's clock is behind
+	// phones that are consistently slow get a little more time
+	extraTolerance := sessions.ExtraTolerance(SRN)
+	timing.ExtraTolerance = extraTolerance
 
-	log.Printf("Clock drift for SRN %s: %d ms", SRN, clockDrift)
-	log.Printf("Latency for SRN %s: %d ms", SRN, studentLatency)
+	log.Printf("Clock drift for SRN %s: %d ms", SRN, timing.ClockDrift)
+	log.Printf("Latency for SRN %s: %d ms", SRN, timing.StudentLatency)
+	if extraTolerance > 0 {
+		log.Printf("Extra tolerance for SRN %s: %d ms", SRN, extraTolerance)
+	}
+
+	if clockSync != nil {
+		conn.Send(protocol.TypeClockSynced, protocol.ClockSynced{ClockDrift: timing.ClockDrift, RoundTrip: timing.RoundTrip, Rounds: timing.Samples})
+
+		// keep measuring while the student is scanning, phones drift and networks change
+		stopPinging := make(chan struct{})
+		defer close(stopPinging)
+		go pingStudentClock(conn, clockSync, stopPinging)
+	}
 
 	// Handle QR code scans
-	var scanMessage models.ScanMessage
+	var scanningSessionID models.ID
+	defer func() {
+		if scanningSessionID != 0 {
+			sessions.ScannerDisconnected(scanningSessionID)
+		}
+	}()
 	for {
-		err := conn.ReadJSON(&scanMessage)
+		var raw json.RawMessage
+		msgType, err := conn.Receive(&raw)
 		if err != nil {
 			// Handle disconnection gracefully
 			log.Printf("Client disconnected or error reading message: %v", err)
 			break
 		}
-		scanMessage.SRN = SRN // work around. because initially, SRN was sent via the scan message. Now it's through cookie
+
+		if msgType == protocol.TypeTimePong && clockSync != nil {
+			var pong protocol.TimePong
+			if err := json.Unmarshal(raw, &pong); err != nil || !clockSync.Pong(pong.ServerTime, pong.ClientReceivedAt, pong.ClientSentAt, sessions.Now().UnixMilli()) {
+				log.Printf("Dropped a pong from %s that answers no ping", SRN)
+			} else {
+				timing = clockSync.Estimate()
+				timing.ExtraTolerance = extraTolerance
+			}
+			continue
+		}
+
+		var scanMessage models.ScanMessage
+		if err := json.Unmarshal(raw, &scanMessage); err != nil {
+			writeScanError(conn, sessions.ErrInvalidMessage)
+			continue
+		}
+		// the logged in identity, not the client's claim
+		scanMessage.SRN = SRN
+
+		if err := sessions.AllowScan(SRN, clientIP, scanMessage.SessionID); err != nil {
+			// the session is only the client's claim here, so it isn't charged for the attempt
+			sessions.AuditScanAttempt(models.ScanAttempt{
+				SRN:             SRN,
+				ClientIP:        clientIP,
+				ConnectedAt:     connectedAt,
+				SessionID:       scanMessage.SessionID,
+				ScannedRandomID: scanMessage.ScannedRandomID,
+				Outcome:         models.ScanOutcomeRejected,
+				FailureReason:   sessions.AsScanError(err).Code,
+			})
+			writeScanError(conn, err)
+			continue
+		}
+
+		// the session is only known once the first QR is scanned
+		if scanningSessionID == 0 {
+			scanningSessionID = scanMessage.SessionID
+			sessions.ScannerConnected(scanningSessionID)
+		}
 
 		// Validate the scanned data
-		isValid, err := sessions.ValidateScan(scanMessage, clockDrift, studentLatency)
-		if isValid {
+		attempt, err := sessions.ValidateScan(scanMessage, timing, clientIP)
+		attempt.ConnectedAt = connectedAt
+		if err == nil && attempt.SpotCheckID != 0 {
+			log.Println(scanMessage.SRN, "re-verified for spot-check", attempt.SpotCheckID)
+			sessions.RecordScanAttempt(attempt)
+			writeScanResult(conn, protocol.StatusOK, "", "Presence re-verified successfully")
+			break
+		} else if err == nil {
 			log.Println(scanMessage.SRN, "being marked present")
 			// Mark student as present
 			err := sessions.MarkStudentPresent(scanMessage.SessionID, scanMessage.SRN)
+			if errors.Is(err, sessions.ErrNotInRoster) {
+				// valid scan from someone outside the roster, let the teacher decide
+				attempt.Outcome = models.ScanOutcomePendingApproval
+				sessions.RecordScanAttempt(attempt)
+				awaitUnrosteredApproval(conn, scanMessage.SessionID, scanMessage.SRN)
+				break
+			}
 			if err != nil {
 				log.Printf("Failed to mark student present: %v", err)
-				conn.WriteJSON(gin.H{"status": "error", "message": "Failed to mark attendance"})
+				recordRejectedAttempt(attempt, err)
+				writeScanError(conn, err)
 				continue
 			}
 
 			// Respond with success and close the connection
-			conn.WriteJSON(gin.H{"status": "OK", "message": "Attendance marked successfully"})
+			sessions.RecordScanAttempt(attempt)
+			writeScanResult(conn, protocol.StatusOK, "", "Attendance marked successfully")
 			break
 		} else {
 			// Respond with failure, keep the connection open for retries
-			errorMessage := err.Error()
-			log.Println(scanMessage.SRN, errorMessage)
-			conn.WriteJSON(gin.H{"status": "error", "message": errorMessage})
+			sessions.RecordScanAttempt(attempt)
+			log.Println(scanMessage.SRN, err.Error())
+			writeScanError(conn, err)
+		}
+	}
+}
+
+// recordRejectedAttempt audits a scan that validated but couldn't be applied
+func recordRejectedAttempt(attempt models.ScanAttempt, err error) {
+	attempt.Outcome = models.ScanOutcomeRejected
+	attempt.FailureReason = sessions.AsScanError(err).Code
+	sessions.RecordScanAttempt(attempt)
+}
+
+// clock sync rounds at connect time, and how often the clock is measured again after
+const (
+	clockSyncRounds     = 5
+	clockResyncInterval = 30 * time.Second
+)
+
+// syncStudentClock runs the opening ping rounds, one at a time
+func syncStudentClock(conn *protocol.Conn, clockSync *sessions.ClockSync) error {
+	for round := 1; round <= clockSyncRounds; round++ {
+		sentAt := sessions.Now().UnixMilli()
+		clockSync.Ping(sentAt)
+		if err := conn.Send(protocol.TypeTimePing, protocol.TimePing{ServerTime: sentAt, Round: round}); err != nil {
+			return err
+		}
+
+		var pong protocol.TimePong
+		msgType, err := conn.Receive(&pong)
+		if err != nil {
+			return err
+		}
+		if msgType != protocol.TypeTimePong {
+			return fmt.Errorf("expected %s, got %s", protocol.TypeTimePong, msgType)
+		}
+		if !clockSync.Pong(pong.ServerTime, pong.ClientReceivedAt, pong.ClientSentAt, sessions.Now().UnixMilli()) {
+			return fmt.Errorf("%s answers no ping", protocol.TypeTimePong)
+		}
+	}
+	return nil
+}
+
+// pingStudentClock pings until stopped. The answers arrive in the scan loop
+func pingStudentClock(conn *protocol.Conn, clockSync *sessions.ClockSync, stop chan struct{}) {
+	ticker := time.NewTicker(clockResyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			sentAt := sessions.Now().UnixMilli()
+			clockSync.Ping(sentAt)
+			if err := conn.Send(protocol.TypeTimePing, protocol.TimePing{ServerTime: sentAt}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// legacyClockSync takes the single clock sample older clients send on connecting.
+// The latency it measures is mostly how long the phone took to send it
+func legacyClockSync(conn *protocol.Conn) (sessions.ScanContext, error) {
+	serverBeforeTime := sessions.Now().UnixMilli()
+
+	// Receive initial timestamp from the client for clock drift calculation
+	var initMessage protocol.ClockSync
+	_, err := conn.Receive(&initMessage)
+
+	serverTime := sessions.Now().UnixMilli()
+
+	if err != nil {
+		return sessions.ScanContext{}, err
+	}
+
+	// Calculate clock drift
+	int64ClientTime, _ := strconv.ParseInt(initMessage.ClientTime, 10, 64)
+	return sessions.ScanContext{
+		ClockDrift:     serverTime - int64ClientTime, // Positive means client's clock is behind
+		StudentLatency: serverTime - serverBeforeTime,
+	}, nil
+}
+
+// how long a student outside the roster waits for the teacher before giving up
+const unrosteredApprovalTimeout = 5 * time.Minute
+
+// awaitUnrosteredApproval queues the student for the teacher's approval and
+// relays the decision back to them
+func awaitUnrosteredApproval(conn *protocol.Conn, sessionID models.ID, SRN string) {
+	decision, err := sessions.QueueUnrosteredScan(sessionID, SRN)
+	if err != nil {
+		log.Printf("Failed to queue unrostered scan: %v", err)
+		writeScanError(conn, err)
+		return
+	}
+
+	log.Println(SRN, "is not on the roster of session", sessionID, "awaiting approval")
+	writeScanResult(conn, protocol.StatusPending, sessions.ErrNotInRoster.Code, "You are not on this class's roster. Waiting for the teacher to approve")
+
+	select {
+	case approved, ok := <-decision:
+		if !ok {
+			writeScanError(conn, sessions.ErrApprovalWithdrawn)
+		} else if approved {
+			writeScanResult(conn, protocol.StatusOK, "", "Attendance marked successfully")
+		} else {
+			writeScanError(conn, sessions.ErrApprovalRejected)
 		}
+	case <-time.After(unrosteredApprovalTimeout):
+		sessions.CancelUnrosteredScan(sessionID, SRN, decision)
+		writeScanError(conn, sessions.ErrApprovalTimeout)
 	}
 }
+
+// writeScanError replies with the error's catalog code and a message the student can act on
+func writeScanError(conn *protocol.Conn, err error) {
+	scanErr := sessions.AsScanError(err)
+	writeScanResult(conn, protocol.StatusError, scanErr.Code, scanErr.Message)
+}
+
+func writeScanResult(conn *protocol.Conn, status, code, message string) {
+	conn.Send(protocol.TypeScanResult, protocol.Status{Status: status, Code: code, Message: message})
+}
@@ -2,16 +2,22 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
-	"math/rand"
 	"net"
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/anuragrao04/qr-attendance-backend/auth"
+	"github.com/anuragrao04/qr-attendance-backend/database"
 	"github.com/anuragrao04/qr-attendance-backend/models"
+	"github.com/anuragrao04/qr-attendance-backend/protocol"
 	"github.com/anuragrao04/qr-attendance-backend/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -19,6 +25,7 @@ import (
 
 // WebSocket upgrader
 var upgrader = websocket.Upgrader{
+	Subprotocols: protocol.Subprotocols,
 	CheckOrigin: func(r *http.Request) bool {
 		origin := r.Header.Get("Origin")
 		if origin == "http://localhost:3000" || origin == "https://attendance.anuragrao.site" {
@@ -30,73 +37,93 @@ var upgrader = websocket.Upgrader{
 }
 
 func CreateSession(c *gin.Context) {
+	actor := auth.Identity(c)
+
+	// check ownership before upgrading, so refusals are plain HTTP errors
+	config, err := sessionConfigFromRequest(c, actor)
+	if err != nil {
+		log.Printf("Refusing to open session for %s: %v", actor, err)
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Upgrade HTTP connection to WebSocket
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Printf("Failed to upgrade to WebSocket: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to establish WebSocket connection"})
 		return
 	}
+	conn := protocol.NewConn(ws)
 	defer conn.Close()
 
 	// Initial latency calibration
-	conn.WriteJSON(models.RandomID{
+	conn.Send(protocol.TypeLatencyProbe, models.RandomID{
 		ID: 1234567890, // dummy random ID to probe the render latency
 	})
 
-	beforeProbe := time.Now().UnixMilli()
-	var initMessage struct {
-		Type    string `json:"type"`
-		Message int64  `json:"message"`
-	}
-	err = conn.ReadJSON(&initMessage)
-	afterProbe := time.Now().UnixMilli()
-	teacherCommunicationLatency := (afterProbe - beforeProbe) / 2
+	beforeProbe := sessions.Now().UnixMilli()
+	var initMessage protocol.RenderLatency
+	_, err = conn.Receive(&initMessage)
+	afterProbe := sessions.Now().UnixMilli()
+	probeRoundTrip := afterProbe - beforeProbe
+	teacherCommunicationLatency := probeRoundTrip / 2
 
 	// Read rendering time
-	err = conn.ReadJSON(&initMessage)
+	_, err = conn.Receive(&initMessage)
 	if err != nil {
 		log.Printf("Failed to read initial client message: %v", err)
-		conn.WriteJSON(gin.H{"status": "error", "message": "Failed to read initial data"})
+		conn.Send(protocol.TypeError, protocol.Status{Status: protocol.StatusError, Message: "Failed to read initial data"})
 		return
 	}
 
 	TotalRenderingLatency := teacherCommunicationLatency + initMessage.Message
 
-	table := c.Query("table")
-	sessionID, students, err := sessions.CreateSession(table, TotalRenderingLatency)
+	// older frontends may keep IDs in 32 bit integers
+	if conn.Version() < protocol.Version3 {
+		config.IDBits = sessions.LegacyIDBits
+	}
+
+	sessionID, students, err := sessions.CreateSession(config, TotalRenderingLatency)
 	if err != nil {
 		log.Printf("Failed to create session: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		conn.Send(protocol.TypeError, protocol.Status{Status: protocol.StatusError, Message: err.Error()})
 		return
 	}
 
-	// Register for attendance change events
+	sessions.RecordTeacherLatency(sessionID, probeRoundTrip, initMessage.Message)
+
+	// newer clients keep answering probes, so the latency follows the screen through the class
+	var probe *latencyProbe
+	if conn.Version() >= protocol.Version5 {
+		probe = &latencyProbe{sentAt: sessions.Now()} // the connect time probe counts as the first
+	}
+
+	// Register for attendance change and session events
 	attendanceEvents := sessions.RegisterForAttendanceChanges(sessionID)
+	sessionEvents := sessions.RegisterForSessionEvents(sessionID)
 
 	// Make sure to clean up when we're done
 	defer func() {
-		sessions.UnregisterFromAttendanceChanges(sessionID)
-		sessions.DeleteSession(sessionID)
+		sessions.UnregisterFromAttendanceChanges(sessionID, attendanceEvents)
+		sessions.UnregisterFromSessionEvents(sessionID, sessionEvents)
+		sessions.EndSession(sessionID)
 		log.Printf("Session %d cleaned up", sessionID)
 	}()
 
 	// Send the session ID to the client
-	err = conn.WriteJSON(gin.H{"sessionID": sessionID, "students": students})
+	err = conn.Send(protocol.TypeSessionStarted, protocol.SessionStarted{SessionID: sessionID, Students: students})
 	if err != nil {
 		log.Printf("Failed to send session ID: %v", err)
 		return
 	}
 
-	// Mutex for WebSocket writes to prevent concurrent access
-	var wsWriteMutex sync.Mutex
-
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// 1. Goroutine for sending random IDs
 	go func() {
-		ticker := time.NewTicker(200 * time.Millisecond)
+		ticker := time.NewTicker(sessions.RandomIDLifetime)
 		defer ticker.Stop()
 
 		for {
@@ -104,17 +131,18 @@ func CreateSession(c *gin.Context) {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				randomID := generateRandomID()
-				err := sessions.UpdateRandomID(sessionID, randomID)
+				randomID, err := sessions.RotateRandomID(sessionID)
 				if err != nil {
 					log.Printf("Failed to update session random ID: %v", err)
 					cancel()
 					return
 				}
 
-				wsWriteMutex.Lock()
-				err = conn.WriteJSON(randomID)
-				wsWriteMutex.Unlock()
+				msgType := protocol.TypeRandomID
+				if probe != nil && probe.start(sessions.Now()) {
+					msgType = protocol.TypeLatencyProbe
+				}
+				err = conn.Send(msgType, randomID)
 
 				if err != nil {
 					log.Printf("Failed to send random ID: %v", err)
@@ -125,57 +153,134 @@ func CreateSession(c *gin.Context) {
 		}
 	}()
 
-	// 2. Goroutine for reading toggle requests
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				var message struct {
-					Type string `json:"type"`
-					SRN  string `json:"srn"`
-				}
+	// 2. Goroutine for reading teacher commands
+	go readTeacherCommands(conn, cancel, sessionID, func() sessions.Access { return sessions.AccessFull }, actor, probe)
 
-				err := conn.ReadJSON(&message)
+	// 3. Main loop to listen for attendance change events
+	streamSessionEvents(ctx, conn, sessionID, attendanceEvents, sessionEvents)
+}
 
-				if err != nil {
-					// If it's a timeout, just continue
-					if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-						continue
-					}
-
-					// Handle other errors
-					if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
-						log.Printf("WebSocket read error: %v", err)
-					}
-					cancel()
-					return
-				}
+// readTeacherCommands processes commands until the socket fails, then cancels the connection.
+// access is asked before every command, as a delegate's can change while connected.
+// probe is nil on sockets that aren't probed
+func readTeacherCommands(conn *protocol.Conn, cancel context.CancelFunc, sessionID models.ID, access func() sessions.Access, actor string, probe *latencyProbe) {
+	for {
+		var raw json.RawMessage
+		commandType, err := conn.Receive(&raw)
+		var message protocol.TeacherCommand
+		if err == nil && len(raw) > 0 {
+			err = json.Unmarshal(raw, &message)
+		}
 
-				// Process toggle request
-				if message.Type == "TOGGLE_ATTENDANCE" && message.SRN != "" {
-					log.Printf("Toggling attendance for SRN: %s in session: %d", message.SRN, sessionID)
-
-					err := sessions.ToggleStudentAttendance(sessionID, message.SRN)
-					if err != nil {
-						log.Printf("Failed to toggle attendance: %v", err)
-
-						wsWriteMutex.Lock()
-						conn.WriteJSON(gin.H{"status": "error", "message": err.Error()})
-						wsWriteMutex.Unlock()
-					} else {
-						wsWriteMutex.Lock()
-						conn.WriteJSON(gin.H{"status": "OK", "message": "Attendance toggled successfully"})
-						wsWriteMutex.Unlock()
-					}
+		if err != nil {
+			// If it's a timeout, just continue
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+
+			// Handle other errors
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("WebSocket read error: %v", err)
+			}
+			cancel()
+			return
+		}
+
+		switch commandType {
+		case protocol.TypeLatencyProbeAck:
+			if probe != nil {
+				probe.ack(sessions.Now())
+			}
+			continue
+		case protocol.TypeRenderLatency:
+			var rendered protocol.RenderLatency
+			if probe != nil && json.Unmarshal(raw, &rendered) == nil {
+				if roundTrip, ok := probe.finish(); ok {
+					sessions.RecordTeacherLatency(sessionID, roundTrip, rendered.Message)
 				}
 			}
+			continue
 		}
-	}()
 
-	// 3. Main loop to listen for attendance change events
-	for {
+		successMessage, err := processTeacherCommand(sessionID, access(), actor, commandType, message)
+		if successMessage == "" && err == nil {
+			// unknown or empty command, ignored like before
+			continue
+		}
+
+		if err != nil {
+			log.Printf("Failed to process %s: %v", commandType, err)
+			conn.Send(protocol.TypeCommandResult, protocol.Status{Status: protocol.StatusError, Message: err.Error()})
+		} else {
+			conn.Send(protocol.TypeCommandResult, protocol.Status{Status: protocol.StatusOK, Message: successMessage})
+		}
+	}
+}
+
+// processTeacherCommand applies a command if the connection's access allows it
+// and returns the message to reply with
+func processTeacherCommand(sessionID models.ID, access sessions.Access, actor string, commandType string, message protocol.TeacherCommand) (string, error) {
+	override := models.ManualOverride{
+		Actor:      actor,
+		ReasonCode: message.ReasonCode,
+		Comment:    message.Comment,
+	}
+
+	switch commandType {
+	case protocol.TypeToggleAttendance, protocol.TypeMarkAllPresent, protocol.TypeMarkAllAbsent, protocol.TypeMarkSRNs, protocol.TypeMarkSRNRange:
+		if access < sessions.AccessToggle {
+			return "", errors.New("you are not allowed to change attendance in this session")
+		}
+	case protocol.TypeApproveUnrostered, protocol.TypeRejectUnrostered, protocol.TypeStartSpotCheck:
+		if access < sessions.AccessFull {
+			return "", errors.New("only the session's teacher can do this")
+		}
+	}
+
+	switch commandType {
+	case protocol.TypeToggleAttendance:
+		if message.SRN == "" {
+			return "", nil
+		}
+		log.Printf("Toggling attendance for SRN: %s in session: %d", message.SRN, sessionID)
+		err := sessions.ToggleStudentAttendance(sessionID, message.SRN, override)
+		return "Attendance toggled successfully", err
+
+	case protocol.TypeMarkAllPresent, protocol.TypeMarkAllAbsent:
+		log.Printf("Processing %s in session: %d", commandType, sessionID)
+		changed, err := sessions.SetAttendanceForAll(sessionID, commandType == protocol.TypeMarkAllPresent, override)
+		return fmt.Sprintf("Attendance updated for %d students", changed), err
+
+	case protocol.TypeMarkSRNs:
+		log.Printf("Marking %d SRNs present=%t in session: %d", len(message.SRNs), message.IsPresent, sessionID)
+		changed, err := sessions.SetAttendanceForSRNs(sessionID, message.SRNs, message.IsPresent, override)
+		return fmt.Sprintf("Attendance updated for %d students", changed), err
+
+	case protocol.TypeMarkSRNRange:
+		log.Printf("Marking SRNs %d-%d present=%t in session: %d", message.From, message.To, message.IsPresent, sessionID)
+		changed, err := sessions.SetAttendanceForSRNRange(sessionID, message.From, message.To, message.IsPresent, override)
+		return fmt.Sprintf("Attendance updated for %d students", changed), err
+
+	case protocol.TypeApproveUnrostered, protocol.TypeRejectUnrostered:
+		approve := commandType == protocol.TypeApproveUnrostered
+		log.Printf("Resolving unrostered SRN: %s approved=%t in session: %d", message.SRN, approve, sessionID)
+		err := sessions.ResolveUnrosteredScan(sessionID, message.SRN, approve, override)
+		return "Request resolved", err
+
+	case protocol.TypeStartSpotCheck:
+		log.Printf("Starting spot-check in session: %d", sessionID)
+		_, err := sessions.StartSpotCheck(sessionID, time.Duration(message.Duration)*time.Second)
+		return "Spot-check started", err
+	}
+
+	return "", nil
+}
+
+// streamSessionEvents forwards attendance updates and session events to the socket
+// until the context is cancelled, a write fails or the session ends. When the session
+// ends both channels close, and whatever is still buffered is sent before returning
+func streamSessionEvents(ctx context.Context, conn *protocol.Conn, sessionID models.ID, attendanceEvents chan sessions.AttendanceChangeEvent, sessionEvents chan sessions.SessionEvent) {
+	for attendanceEvents != nil || sessionEvents != nil {
 		select {
 		case <-ctx.Done():
 			return
@@ -184,45 +289,202 @@ func CreateSession(c *gin.Context) {
 			if !ok {
 				// Channel closed
 				log.Printf("Attendance event channel closed for session %d", sessionID)
-				return
+				attendanceEvents = nil
+				continue
 			}
 
-			// Sort the lists
-			sort.Slice(event.Absentees, func(i, j int) bool {
-				last3i, _ := strconv.Atoi(event.Absentees[i].SRN[len(event.Absentees[i].SRN)-3:])
-				last3j, _ := strconv.Atoi(event.Absentees[j].SRN[len(event.Absentees[j].SRN)-3:])
-				return last3i < last3j
-			})
-
-			sort.Slice(event.Presentees, func(i, j int) bool {
-				last3i, _ := strconv.Atoi(event.Presentees[i].SRN[len(event.Presentees[i].SRN)-3:])
-				last3j, _ := strconv.Atoi(event.Presentees[j].SRN[len(event.Presentees[j].SRN)-3:])
-				return last3i < last3j
-			})
-
 			// Send updated lists to client
-			wsWriteMutex.Lock()
-			err := conn.WriteJSON(gin.H{
-				"type":       "ATTENDANCE_UPDATE",
-				"absentees":  event.Absentees,
-				"presentees": event.Presentees,
-			})
-			wsWriteMutex.Unlock()
+			err := writeAttendanceUpdate(conn, event.Absentees, event.Presentees)
 
 			if err != nil {
 				log.Printf("Failed to send attendance lists: %v", err)
 				return
 			}
+
+		case event, ok := <-sessionEvents:
+			if !ok {
+				log.Printf("Session event channel closed for session %d", sessionID)
+				sessionEvents = nil
+				continue
+			}
+
+			err := conn.Send(event.Type, event.Payload)
+
+			if err != nil {
+				log.Printf("Failed to send %s event: %v", event.Type, err)
+				return
+			}
+		}
+	}
+}
+
+// writeAttendanceUpdate sorts the lists by roll number and sends them
+func writeAttendanceUpdate(conn *protocol.Conn, absentees, presentees []models.StudentInASession) error {
+	// Sort the lists
+	sort.Slice(absentees, func(i, j int) bool {
+		last3i, _ := strconv.Atoi(absentees[i].SRN[len(absentees[i].SRN)-3:])
+		last3j, _ := strconv.Atoi(absentees[j].SRN[len(absentees[j].SRN)-3:])
+		return last3i < last3j
+	})
+
+	sort.Slice(presentees, func(i, j int) bool {
+		last3i, _ := strconv.Atoi(presentees[i].SRN[len(presentees[i].SRN)-3:])
+		last3j, _ := strconv.Atoi(presentees[j].SRN[len(presentees[j].SRN)-3:])
+		return last3i < last3j
+	})
+
+	return conn.Send(protocol.TypeAttendanceUpdate, protocol.AttendanceUpdate{Absentees: absentees, Presentees: presentees})
+}
+
+// sessionConfigFromRequest resolves the classrooms of a new session. Sessions are normally opened
+// for a course offering; passing classroom tables directly is kept for ad hoc sessions
+func sessionConfigFromRequest(c *gin.Context, owner string) (models.SessionConfig, error) {
+	config := models.SessionConfig{Owner: owner}
+
+	geofence, err := geofenceFromRequest(c)
+	if err != nil {
+		return config, err
+	}
+	config.Geofence = geofence
+
+	network, err := networkRestrictionFromRequest(c)
+	if err != nil {
+		return config, err
+	}
+	config.Network = network
+
+	if rawOfferingID := c.Query("offering"); rawOfferingID != "" {
+		offeringID, err := strconv.ParseUint(rawOfferingID, 10, 64)
+		if err != nil {
+			return config, errors.New("invalid course offering ID")
+		}
+		offering, err := database.GetCourseOffering(uint(offeringID))
+		if err != nil {
+			return config, fmt.Errorf("course offering %d not found", offeringID)
+		}
+		if !canOpenOffering(c, owner, offering) {
+			return config, fmt.Errorf("you don't teach course offering %d", offeringID)
+		}
+		config.CourseOfferingID = offering.ID
+		config.ClassroomTables = offering.ClassroomTables()
+		return config, nil
+	}
+
+	if !auth.HasPermission(c, models.PermCreateSession) {
+		return config, errors.New("only teachers can open sessions without a course offering")
+	}
+
+	// combined sessions pass several classrooms, either as repeated or comma separated table params
+	for _, table := range c.QueryArray("table") {
+		for _, name := range strings.Split(table, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				config.ClassroomTables = append(config.ClassroomTables, name)
+			}
+		}
+	}
+	return config, nil
+}
+
+// geofenceFromRequest reads a geofence the teacher passed for the session: lat, lng and radius
+// in metres for a fence of their own, and geofence for its mode, or the classroom fence's
+func geofenceFromRequest(c *gin.Context) (models.Geofence, error) {
+	geofence := models.Geofence{Mode: c.Query("geofence")}
+	if c.Query("lat") == "" && c.Query("lng") == "" && c.Query("radius") == "" {
+		if geofence.Mode != "" {
+			return geofence, sessions.ValidateGeofenceMode(geofence.Mode)
+		}
+		return geofence, nil
+	}
+
+	var err error
+	for _, param := range []struct {
+		name  string
+		value *float64
+	}{{"lat", &geofence.Latitude}, {"lng", &geofence.Longitude}, {"radius", &geofence.Radius}} {
+		if *param.value, err = strconv.ParseFloat(c.Query(param.name), 64); err != nil {
+			return geofence, fmt.Errorf("invalid %s, lat, lng and radius go together", param.name)
 		}
 	}
+	return geofence, sessions.ValidateGeofence(&geofence)
+}
+
+// networkRestrictionFromRequest reads the client networks the teacher allowed for the session,
+// as repeated or comma separated networks params, and network for its mode, or the classroom's
+func networkRestrictionFromRequest(c *gin.Context) (models.NetworkRestriction, error) {
+	restriction := models.NetworkRestriction{Mode: c.Query("network")}
+	for _, networks := range c.QueryArray("networks") {
+		for _, cidr := range strings.Split(networks, ",") {
+			if cidr = strings.TrimSpace(cidr); cidr != "" {
+				restriction.CIDRs = append(restriction.CIDRs, cidr)
+			}
+		}
+	}
+	if len(restriction.CIDRs) == 0 {
+		if restriction.Mode != "" {
+			return restriction, sessions.ValidateNetworkMode(restriction.Mode)
+		}
+		return restriction, nil
+	}
+	return restriction, sessions.ValidateNetworkRestriction(&restriction)
+}
+
+// canOpenOffering lets the offering's teachers, admins and TAs holding a full
+// delegation for the offering run its QR
+func canOpenOffering(c *gin.Context, identity string, offering models.CourseOffering) bool {
+	if offering.HasTeacher(identity) && auth.HasPermission(c, models.PermCreateSession) {
+		return true
+	}
+	if auth.HasPermission(c, models.PermManageAnySession) {
+		return true
+	}
+	access, err := sessions.DelegatedAccess(identity, offering.ID, 0)
+	if err != nil {
+		log.Printf("Failed to check delegations of %s: %v", identity, err)
+		return false
+	}
+	return access == sessions.AccessFull
+}
+
+// how often the teacher's screen is probed during a session
+const latencyProbeInterval = 15 * time.Second
+
+// latencyProbe tracks the probes of one teacher socket. Only one is in flight at a time;
+// one that goes unanswered for a whole interval is given up on
+type latencyProbe struct {
+	mutex     sync.Mutex
+	sentAt    time.Time
+	roundTrip int64 // ms, -1 until the probe is acknowledged
+	inFlight  bool
+}
+
+// start reports whether the next QR should go out as a probe, and if so marks it sent
+func (p *latencyProbe) start(now time.Time) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.sentAt.IsZero() && now.Sub(p.sentAt) < latencyProbeInterval {
+		return false
+	}
+	p.sentAt = now
+	p.roundTrip = -1
+	p.inFlight = true
+	return true
+}
+
+func (p *latencyProbe) ack(now time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.inFlight && p.roundTrip < 0 {
+		p.roundTrip = now.Sub(p.sentAt).Milliseconds()
+	}
 }
 
-// Generate a new random ID
-func generateRandomID() models.RandomID {
-	now := time.Now().UnixMilli() // Get current time in milliseconds
-	return models.RandomID{
-		ID:        uint32(rand.Uint32()),
-		CreatedAt: now,
-		ExpiredAt: now + 200, // ID is valid for 200 ms
+// finish returns the round trip of the probe in flight, if it was acknowledged
+func (p *latencyProbe) finish() (int64, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.inFlight || p.roundTrip < 0 {
+		return 0, false
 	}
+	p.inFlight = false
+	return p.roundTrip, true
 }
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/database"
+	"github.com/anuragrao04/qr-attendance-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+func CreateTimetableSlot(c *gin.Context) {
+	var slot models.TimetableSlot
+	if err := c.ShouldBindJSON(&slot); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if slot.ClassroomTable == "" || slot.Course == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "course and classroomTable are required"})
+		return
+	}
+	if slot.Weekday < time.Sunday || slot.Weekday > time.Saturday {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "weekday must be between 0 (Sunday) and 6 (Saturday)"})
+		return
+	}
+	// "15:04" strings compare correctly as long as they are zero padded, which parsing enforces
+	if _, err := time.Parse("15:04", slot.StartTime); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "startTime must look like 09:00"})
+		return
+	}
+	if _, err := time.Parse("15:04", slot.EndTime); err != nil || slot.EndTime <= slot.StartTime {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "endTime must look like 10:00 and be after startTime"})
+		return
+	}
+
+	slot.ID = 0
+	if err := database.CreateTimetableSlot(&slot); err != nil {
+		log.Printf("Failed to create timetable slot: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, slot)
+}
+
+func GetTimetable(c *gin.Context) {
+	slots, err := database.GetTimetableSlots()
+	if err != nil {
+		log.Printf("Failed to fetch timetable: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"slots": slots})
+}
+
+func DeleteTimetableSlot(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid slot ID"})
+		return
+	}
+	if err := database.DeleteTimetableSlot(uint(id)); err != nil {
+		log.Printf("Failed to delete timetable slot: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "success"})
+}
+
+// GetMissedClasses lists timetable slots no session was held for, between from and to (2006-01-02).
+// Defaults to the last 7 days
+func GetMissedClasses(c *gin.Context) {
+	now := time.Now()
+	from := c.DefaultQuery("from", now.AddDate(0, 0, -7).Format("2006-01-02"))
+	to := c.DefaultQuery("to", now.Format("2006-01-02"))
+	if _, err := time.Parse("2006-01-02", from); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must look like 2006-01-02"})
+		return
+	}
+	if _, err := time.Parse("2006-01-02", to); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must look like 2006-01-02"})
+		return
+	}
+
+	missed, err := database.GetMissedSlots(from, to)
+	if err != nil {
+		log.Printf("Failed to fetch missed classes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"missed": missed})
+}
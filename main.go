@@ -1,9 +1,18 @@
 package main
 
 import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/anomaly"
 	"github.com/anuragrao04/qr-attendance-backend/auth"
 	"github.com/anuragrao04/qr-attendance-backend/database"
 	"github.com/anuragrao04/qr-attendance-backend/handlers"
+	"github.com/anuragrao04/qr-attendance-backend/models"
+	"github.com/anuragrao04/qr-attendance-backend/sessions"
 	"github.com/gin-gonic/gin"
 )
 
@@ -16,11 +25,150 @@ func main() {
 	// webauthn
 	auth.Init()
 
+	// timetable
+	sessions.StartMissedClassScheduler(5 * time.Minute)
+
+	// scan audit, kept for SCAN_AUDIT_RETENTION_DAYS
+	retentionDays := 30
+	if raw := os.Getenv("SCAN_AUDIT_RETENTION_DAYS"); raw != "" {
+		days, err := strconv.Atoi(raw)
+		if err != nil || days <= 0 {
+			log.Fatalf("Invalid SCAN_AUDIT_RETENTION_DAYS %q", raw)
+		}
+		retentionDays = days
+	}
+	sessions.StartScanAuditWriter(time.Second, time.Duration(retentionDays)*24*time.Hour)
+
+	// scan rate limits, as attempts/period like 10/10s, and the lockout after repeated failures
+	limits := sessions.DefaultRateLimits
+	rates := map[string]*sessions.Rate{
+		"SCAN_RATE_PER_SRN":     &limits.PerSRN,
+		"SCAN_RATE_PER_IP":      &limits.PerIP,
+		"SCAN_RATE_PER_SESSION": &limits.PerSession,
+	}
+	for name, rate := range rates {
+		if raw := os.Getenv(name); raw != "" {
+			parsed, err := sessions.ParseRate(raw)
+			if err != nil {
+				log.Fatalf("Invalid %s: %v", name, err)
+			}
+			*rate = parsed
+		}
+	}
+	if raw := os.Getenv("SCAN_LOCKOUT_AFTER"); raw != "" {
+		failures, err := strconv.Atoi(raw)
+		if err != nil || failures < 0 {
+			log.Fatalf("Invalid SCAN_LOCKOUT_AFTER %q", raw)
+		}
+		limits.LockoutAfter = failures
+	}
+	if raw := os.Getenv("SCAN_LOCKOUT_DURATION"); raw != "" {
+		duration, err := time.ParseDuration(raw)
+		if err != nil || duration <= 0 {
+			log.Fatalf("Invalid SCAN_LOCKOUT_DURATION %q", raw)
+		}
+		limits.LockoutDuration = duration
+	}
+	sessions.SetRateLimits(limits)
+
+	// proxy attendance detection. The shared address rule is off unless ANOMALY_MAX_SRNS_PER_IP
+	// is set, campuses usually put every student behind one NAT
+	maxSRNsPerIP := 0
+	if raw := os.Getenv("ANOMALY_MAX_SRNS_PER_IP"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			log.Fatalf("Invalid ANOMALY_MAX_SRNS_PER_IP %q", raw)
+		}
+		maxSRNsPerIP = parsed
+	}
+	anomaly.Start(anomaly.NewDetector(0.5, anomaly.DefaultRules(maxSRNsPerIP)...))
+
+	if secret := os.Getenv("SESSION_SECRET"); secret != "" {
+		auth.SetSessionSecret([]byte(secret))
+	} else {
+		log.Println("SESSION_SECRET not set, everyone has to log in again after a restart")
+	}
+
+	// the first admin has to be bootstrapped, everyone else gets roles through the admin API.
+	// They register their passkey like anyone else, then restart with ADMIN_SRN set
+	if adminSRN := os.Getenv("ADMIN_SRN"); adminSRN != "" {
+		if err := database.GrantRole(adminSRN, models.RoleAdmin); err != nil {
+			log.Printf("Failed to grant admin role to %s: %v", adminSRN, err)
+		}
+	}
+
 	// router
+	router := setupRouter()
+
+	// where the real client address comes from, for rate limits and network restrictions.
+	// Forwarding headers are only believed from TRUSTED_PROXIES, comma separated addresses
+	// or CIDRs, so students can't claim a campus address by sending the header themselves.
+	// CLIENT_IP_HEADER names the header the proxy sets, X-Forwarded-For by default
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	if header := os.Getenv("CLIENT_IP_HEADER"); header != "" {
+		router.RemoteIPHeaders = []string{header}
+	}
+
+	router.Run(":6969")
+}
+
+// setupRouter declares every route along with the permission it requires
+func setupRouter() *gin.Engine {
 	router := gin.Default()
-	router.GET("/create-attendance-session", handlers.CreateSession)
-	router.GET("/scan-qr", handlers.StudentScan)
+	// TAs reach the teacher socket through delegations, checked inside the handler
+	router.GET("/create-attendance-session", auth.RequireAnyPermission(models.PermCreateSession, models.PermJoinSession), handlers.CreateSession)
+	router.GET("/join-attendance-session", auth.RequirePermission(models.PermJoinSession), handlers.JoinSession)
+	router.GET("/observe-attendance-session", auth.RequirePermission(models.PermObserveSession), handlers.ObserveSession)
+	router.GET("/scan-qr", auth.RequirePermission(models.PermScanAttendance), handlers.StudentScan)
+
+	router.GET("/delegations", auth.RequireAnyPermission(models.PermDelegate, models.PermJoinSession), handlers.GetDelegations)
+	router.POST("/delegations", auth.RequirePermission(models.PermDelegate), handlers.CreateDelegation)
+	router.DELETE("/delegations/:id", auth.RequirePermission(models.PermDelegate), handlers.RevokeDelegation)
+
+	router.GET("/admin/attendance-audit", auth.RequirePermission(models.PermViewAudit), handlers.GetAttendanceAudit)
+	router.GET("/admin/scan-attempts", auth.RequirePermission(models.PermViewAudit), handlers.GetScanAttempts)
+	router.GET("/admin/timing-profiles", auth.RequirePermission(models.PermViewAudit), handlers.GetTimingProfiles)
+
+	router.GET("/admin/sessions", auth.RequirePermission(models.PermAdminSessions), handlers.GetLiveSessions)
+	router.POST("/admin/sessions/:id/end", auth.RequirePermission(models.PermAdminSessions), handlers.EndLiveSession)
 
+	router.GET("/admin/timetable", auth.RequirePermission(models.PermViewTimetable), handlers.GetTimetable)
+	router.POST("/admin/timetable", auth.RequirePermission(models.PermManageTimetable), handlers.CreateTimetableSlot)
+	router.DELETE("/admin/timetable/:id", auth.RequirePermission(models.PermManageTimetable), handlers.DeleteTimetableSlot)
+	router.GET("/admin/missed-classes", auth.RequirePermission(models.PermViewTimetable), handlers.GetMissedClasses)
+
+	router.GET("/admin/courses", auth.RequirePermission(models.PermViewCourses), handlers.GetCourses)
+	router.POST("/admin/courses", auth.RequirePermission(models.PermManageCourses), handlers.CreateCourse)
+	router.GET("/admin/course-offerings", auth.RequirePermission(models.PermViewCourses), handlers.GetCourseOfferings)
+	router.POST("/admin/course-offerings", auth.RequirePermission(models.PermManageCourses), handlers.CreateCourseOffering)
+	router.GET("/admin/classroom-geofences", auth.RequirePermission(models.PermViewCourses), handlers.GetClassroomGeofences)
+	router.PUT("/admin/classroom-geofences/:table", auth.RequirePermission(models.PermManageCourses), handlers.SaveClassroomGeofence)
+	router.DELETE("/admin/classroom-geofences/:table", auth.RequirePermission(models.PermManageCourses), handlers.DeleteClassroomGeofence)
+	router.GET("/admin/classroom-networks", auth.RequirePermission(models.PermViewCourses), handlers.GetClassroomNetworks)
+	router.PUT("/admin/classroom-networks/:table", auth.RequirePermission(models.PermManageCourses), handlers.SaveClassroomNetwork)
+	router.DELETE("/admin/classroom-networks/:table", auth.RequirePermission(models.PermManageCourses), handlers.DeleteClassroomNetwork)
+
+	router.GET("/admin/roles", auth.RequirePermission(models.PermManageRoles), handlers.GetRoles)
+	router.PUT("/admin/roles/:name", auth.RequirePermission(models.PermManageRoles), handlers.SaveRole)
+	router.GET("/admin/users/:srn/roles", auth.RequirePermission(models.PermManageRoles), handlers.GetUserRoles)
+	router.PUT("/admin/users/:srn/roles", auth.RequirePermission(models.PermManageRoles), handlers.SetUserRoles)
+
+	router.GET("/reports/me", auth.RequirePermission(models.PermViewOwnReport), handlers.GetOwnReport)
+	router.GET("/reports/course-offerings/:id", auth.RequirePermission(models.PermViewReports), handlers.GetCourseOfferingReport)
+	router.GET("/reports/students/:srn", auth.RequirePermission(models.PermViewReports), handlers.GetStudentReport)
+	router.GET("/reports/session-records/:id/export", auth.RequirePermission(models.PermExportReports), handlers.ExportSessionRecord)
+	router.GET("/reports/session-records/:id/anomalies", auth.RequirePermission(models.PermViewReports), handlers.GetSessionAnomalies)
+	router.GET("/reports/session-records/:id/spot-checks", auth.RequirePermission(models.PermViewReports), handlers.GetSessionSpotChecks)
+
+	// public: registration and login establish the identity every other route is checked against
 	router.POST("/auth/register/begin", auth.BeginRegistration)
 	router.POST("/auth/register/finish", auth.FinishRegistration)
 
@@ -30,5 +178,5 @@ func main() {
 	router.GET("/auth/check-if-registered-from-cookie", auth.CheckIfRegisteredCookie)
 	router.GET("/auth/check-if-registered-from-header", auth.CheckIfRegisteredHeader)
 
-	router.Run(":6969")
+	return router
 }
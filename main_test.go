@@ -0,0 +1,671 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/auth"
+	"github.com/anuragrao04/qr-attendance-backend/client"
+	"github.com/anuragrao04/qr-attendance-backend/database"
+	"github.com/anuragrao04/qr-attendance-backend/models"
+	"github.com/anuragrao04/qr-attendance-backend/protocol"
+	"github.com/anuragrao04/qr-attendance-backend/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+)
+
+func setupTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	if err := database.OpenGORM("file:" + t.Name() + "?mode=memory&cache=shared"); err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	auth.SetSessionSecret(testSessionSecret)
+	for srn, role := range map[string]string{
+		"PES1UG21CS001": models.RoleStudent,
+		"T001":          models.RoleTeacher,
+		"TA001":         models.RoleTA,
+		"A001":          models.RoleAdmin,
+	} {
+		registerTestUser(t, srn)
+		if _, err := database.SetUserRoles(srn, []string{role}); err != nil {
+			t.Fatalf("failed to grant %s to %s: %v", role, srn, err)
+		}
+	}
+	return setupRouter()
+}
+
+var testSessionSecret = []byte("test session secret")
+
+// registerTestUser creates the user with a passkey, like a finished registration would
+func registerTestUser(t *testing.T, srn string) {
+	t.Helper()
+	user, err := database.GetUser(srn)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		user, err = database.CreateUser(srn)
+	}
+	if err != nil {
+		t.Fatalf("failed to create %s: %v", srn, err)
+	}
+	if len(user.Credentials) == 0 {
+		if err := database.AddCredential(srn, &webauthn.Credential{ID: []byte(srn)}); err != nil {
+			t.Fatalf("failed to add a credential for %s: %v", srn, err)
+		}
+	}
+}
+
+// logIn attaches the session cookie a login as identity would have set
+func logIn(req *http.Request, identity string) {
+	req.AddCookie(&http.Cookie{Name: auth.SessionCookie, Value: auth.SignSession(testSessionSecret, identity, time.Now().Add(time.Hour))})
+}
+
+func TestRoutePermissions(t *testing.T) {
+	router := setupTestRouter(t)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		identity string
+		want     int
+	}{
+		{"anonymous admin route", "GET", "/admin/roles", "", http.StatusUnauthorized},
+		{"unknown user", "GET", "/reports/me", "nobody", http.StatusForbidden},
+		{"student own report", "GET", "/reports/me", "PES1UG21CS001", http.StatusOK},
+		{"student other report", "GET", "/reports/students/PES1UG21CS002", "PES1UG21CS001", http.StatusForbidden},
+		{"student audit", "GET", "/admin/attendance-audit?srn=PES1UG21CS001", "PES1UG21CS001", http.StatusForbidden},
+		{"student timetable", "GET", "/admin/timetable", "PES1UG21CS001", http.StatusForbidden},
+		{"teacher student report", "GET", "/reports/students/PES1UG21CS001", "T001", http.StatusOK},
+		{"teacher timetable", "GET", "/admin/timetable", "T001", http.StatusOK},
+		{"teacher manage roles", "GET", "/admin/roles", "T001", http.StatusForbidden},
+		{"teacher audit", "GET", "/admin/attendance-audit?srn=PES1UG21CS001", "T001", http.StatusForbidden},
+		{"ta export", "GET", "/reports/session-records/1/export", "TA001", http.StatusForbidden},
+		{"student anomalies", "GET", "/reports/session-records/1/anomalies", "PES1UG21CS001", http.StatusForbidden},
+		{"ta anomalies of unknown session", "GET", "/reports/session-records/1/anomalies", "TA001", http.StatusNotFound},
+		{"student spot-checks", "GET", "/reports/session-records/1/spot-checks", "PES1UG21CS001", http.StatusForbidden},
+		{"ta spot-checks of unknown session", "GET", "/reports/session-records/1/spot-checks", "TA001", http.StatusNotFound},
+		{"ta opens ad hoc session", "GET", "/create-attendance-session?table=CSE_A", "TA001", http.StatusForbidden},
+		{"ta opens undelegated offering", "GET", "/create-attendance-session?offering=1", "TA001", http.StatusForbidden},
+		{"student joins session", "GET", "/join-attendance-session?session=1", "PES1UG21CS001", http.StatusForbidden},
+		{"ta joins unknown session", "GET", "/join-attendance-session?session=1", "TA001", http.StatusNotFound},
+		{"student observes session", "GET", "/observe-attendance-session?session=1", "PES1UG21CS001", http.StatusForbidden},
+		{"teacher observes session", "GET", "/observe-attendance-session?session=1", "T001", http.StatusForbidden},
+		{"ta observes without a delegation", "GET", "/observe-attendance-session?session=1", "TA001", http.StatusForbidden},
+		{"admin observes unknown session", "GET", "/observe-attendance-session?session=1", "A001", http.StatusNotFound},
+		{"ta delegates", "POST", "/delegations", "TA001", http.StatusForbidden},
+		{"ta lists delegations", "GET", "/delegations", "TA001", http.StatusOK},
+		{"student opens session", "GET", "/create-attendance-session", "PES1UG21CS001", http.StatusForbidden},
+		{"teacher scans", "GET", "/scan-qr", "T001", http.StatusForbidden},
+		{"teacher live sessions", "GET", "/admin/sessions", "T001", http.StatusForbidden},
+		{"admin live sessions", "GET", "/admin/sessions", "A001", http.StatusOK},
+		{"admin ends unknown session", "POST", "/admin/sessions/1/end", "A001", http.StatusNotFound},
+		{"admin roles", "GET", "/admin/roles", "A001", http.StatusOK},
+		{"admin audit", "GET", "/admin/attendance-audit?srn=PES1UG21CS001", "A001", http.StatusOK},
+		{"teacher scan attempts", "GET", "/admin/scan-attempts", "T001", http.StatusForbidden},
+		{"admin scan attempts", "GET", "/admin/scan-attempts?srn=PES1UG21CS001&from=2026-01-01T00:00:00Z", "A001", http.StatusOK},
+		{"admin scan attempts of a session", "GET", "/admin/scan-attempts?sessionID=42", "A001", http.StatusOK},
+		{"admin scan attempts of an unknown record", "GET", "/admin/scan-attempts?record=999", "A001", http.StatusNotFound},
+		{"admin scan attempts bad record", "GET", "/admin/scan-attempts?record=last", "A001", http.StatusBadRequest},
+		{"teacher timing profiles", "GET", "/admin/timing-profiles", "T001", http.StatusForbidden},
+		{"admin timing profiles", "GET", "/admin/timing-profiles?all=true", "A001", http.StatusOK},
+		{"teacher classroom geofences", "GET", "/admin/classroom-geofences", "T001", http.StatusOK},
+		{"teacher sets classroom geofence", "PUT", "/admin/classroom-geofences/CSE_A", "T001", http.StatusForbidden},
+		{"admin deletes missing classroom geofence", "DELETE", "/admin/classroom-geofences/CSE_A", "A001", http.StatusNotFound},
+		{"teacher opens session with bad geofence", "GET", "/create-attendance-session?table=CSE_A&lat=12.9&lng=77.5", "T001", http.StatusForbidden},
+		{"teacher classroom networks", "GET", "/admin/classroom-networks", "T001", http.StatusOK},
+		{"teacher sets classroom networks", "PUT", "/admin/classroom-networks/CSE_A", "T001", http.StatusForbidden},
+		{"admin deletes missing classroom networks", "DELETE", "/admin/classroom-networks/CSE_A", "A001", http.StatusNotFound},
+		{"teacher opens session with bad networks", "GET", "/create-attendance-session?table=CSE_A&networks=campus", "T001", http.StatusForbidden},
+		{"admin scan attempts bad range", "GET", "/admin/scan-attempts?from=yesterday", "A001", http.StatusBadRequest},
+		{"public registration check", "GET", "/auth/check-if-registered-from-cookie", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.identity != "" {
+				logIn(req, tt.identity)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("%s %s as %q: got status %d, want %d (%s)", tt.method, tt.path, tt.identity, w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequestsNeedASignedSession(t *testing.T) {
+	router := setupTestRouter(t)
+
+	valid := auth.SignSession(testSessionSecret, "PES1UG21CS001", time.Now().Add(time.Hour))
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		want   int
+	}{
+		{"logged in", &http.Cookie{Name: auth.SessionCookie, Value: valid}, http.StatusOK},
+		{"bare SRN cookie", &http.Cookie{Name: "SRN", Value: "A001"}, http.StatusUnauthorized},
+		{"claimed identity", &http.Cookie{Name: auth.SessionCookie, Value: "QTAwMQ." + valid[strings.Index(valid, ".")+1:]}, http.StatusUnauthorized},
+		{"other secret", &http.Cookie{Name: auth.SessionCookie, Value: auth.SignSession([]byte("guess"), "A001", time.Now().Add(time.Hour))}, http.StatusUnauthorized},
+		{"expired", &http.Cookie{Name: auth.SessionCookie, Value: auth.SignSession(testSessionSecret, "A001", time.Now().Add(-time.Minute))}, http.StatusUnauthorized},
+		{"garbage", &http.Cookie{Name: auth.SessionCookie, Value: "A001"}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/reports/me", nil)
+			req.AddCookie(tt.cookie)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d (%s)", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRolesNeedARegisteredUser(t *testing.T) {
+	setupTestRouter(t)
+
+	if err := database.GrantRole("T999", models.RoleTeacher); !errors.Is(err, database.ErrNotRegistered) {
+		t.Errorf("granting to an unknown user: got %v, want ErrNotRegistered", err)
+	}
+	if _, err := database.GetUser("T999"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("granting created the user: %v", err)
+	}
+
+	// a registration that was started but never finished
+	if _, err := database.CreateUser("T998"); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if _, err := database.SetUserRoles("T998", []string{models.RoleTeacher}); !errors.Is(err, database.ErrNotRegistered) {
+		t.Errorf("setting roles of a user without a passkey: got %v, want ErrNotRegistered", err)
+	}
+}
+
+func TestRolesAreEditable(t *testing.T) {
+	router := setupTestRouter(t)
+
+	if _, err := database.SaveRole(models.RoleTA, []string{models.PermViewReports, models.PermExportReports}); err != nil {
+		t.Fatalf("failed to edit role: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/reports/session-records/1/export", nil)
+	logIn(req, "TA001")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// allowed through, the record just doesn't exist
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d after granting export to TAs, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestSeedRolesAddsNewPermissionsOnce(t *testing.T) {
+	router := setupTestRouter(t)
+
+	status := func(path, identity string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		logIn(req, identity)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// an install seeded before the admin sessions permission existed
+	if _, err := database.SaveRole(models.RoleAdmin, slices.DeleteFunc(slices.Clone(models.AllPermissions), func(name string) bool {
+		return name == models.PermAdminSessions
+	})); err != nil {
+		t.Fatalf("failed to edit role: %v", err)
+	}
+	database.GORMDB.Where("role = ? AND permission = ?", models.RoleAdmin, models.PermAdminSessions).Delete(&models.SeededGrant{})
+	if code := status("/admin/sessions", "A001"); code != http.StatusForbidden {
+		t.Fatalf("got status %d before reseeding, want %d", code, http.StatusForbidden)
+	}
+	if err := database.SeedRoles(); err != nil {
+		t.Fatalf("failed to seed roles: %v", err)
+	}
+	if code := status("/admin/sessions", "A001"); code != http.StatusOK {
+		t.Errorf("admin got status %d after reseeding, want the new permission", code)
+	}
+
+	// an install seeded while TAs could observe without a delegation
+	if _, err := database.SaveRole(models.RoleTA, append(slices.Clone(models.DefaultRolePermissions[models.RoleTA]), models.PermObserveSession)); err != nil {
+		t.Fatalf("failed to edit role: %v", err)
+	}
+	database.GORMDB.Create(&models.SeededGrant{Role: models.RoleTA, Permission: models.PermObserveSession})
+	if err := database.SeedRoles(); err != nil {
+		t.Fatalf("failed to seed roles: %v", err)
+	}
+	if code := status("/observe-attendance-session?session=1", "TA001"); code != http.StatusForbidden {
+		t.Errorf("TA got status %d observing after reseeding, want the dropped default taken back", code)
+	}
+
+	// taken away by an admin, stays away
+	if _, err := database.SaveRole(models.RoleTA, []string{models.PermViewOwnReport}); err != nil {
+		t.Fatalf("failed to edit role: %v", err)
+	}
+	if err := database.SeedRoles(); err != nil {
+		t.Fatalf("failed to seed roles: %v", err)
+	}
+	if code := status("/admin/courses", "TA001"); code != http.StatusForbidden {
+		t.Errorf("TA got status %d after reseeding, want the removed permission to stay removed", code)
+	}
+}
+
+func TestDelegatedOfferingOwnership(t *testing.T) {
+	router := setupTestRouter(t)
+
+	offering := models.CourseOffering{
+		CourseID:   1,
+		Teachers:   []models.CourseOfferingTeacher{{TeacherID: "T001"}},
+		Classrooms: []models.CourseOfferingClassroom{{ClassroomTable: "CSE_A"}},
+	}
+	if err := database.CreateCourseOffering(&offering); err != nil {
+		t.Fatalf("failed to create offering: %v", err)
+	}
+
+	openSession := func(identity string) int {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/create-attendance-session?offering=%d", offering.ID), nil)
+		logIn(req, identity)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// anything but 403 means the ownership check passed; the request then fails the websocket upgrade
+	if code := openSession("T001"); code == http.StatusForbidden {
+		t.Errorf("teacher of the offering was refused")
+	}
+	if code := openSession("TA001"); code != http.StatusForbidden {
+		t.Errorf("TA without a delegation got status %d, want %d", code, http.StatusForbidden)
+	}
+
+	delegate := func(scope string, expiresAt time.Time) {
+		err := database.CreateDelegation(&models.Delegation{
+			DelegatorID:      "T001",
+			DelegateID:       "TA001",
+			CourseOfferingID: offering.ID,
+			Scope:            scope,
+			ExpiresAt:        expiresAt,
+		})
+		if err != nil {
+			t.Fatalf("failed to create delegation: %v", err)
+		}
+	}
+
+	delegate(models.DelegationToggle, time.Now().Add(time.Hour))
+	if code := openSession("TA001"); code != http.StatusForbidden {
+		t.Errorf("TA with a toggle delegation got status %d, want %d", code, http.StatusForbidden)
+	}
+
+	delegate(models.DelegationFull, time.Now().Add(-time.Minute))
+	if code := openSession("TA001"); code != http.StatusForbidden {
+		t.Errorf("TA with an expired full delegation got status %d, want %d", code, http.StatusForbidden)
+	}
+
+	delegate(models.DelegationFull, time.Now().Add(time.Hour))
+	if code := openSession("TA001"); code == http.StatusForbidden {
+		t.Errorf("TA with a full delegation was refused")
+	}
+}
+
+func TestDelegatesLoseAccessWhileConnected(t *testing.T) {
+	router := setupTestRouter(t)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	const sessionID = 779
+	sessions.SessionsMutex.Lock()
+	sessions.Sessions[sessionID] = models.Session{
+		Owner:           "T001",
+		ClassroomTables: []string{"CSE_A"},
+		Students:        []models.StudentInASession{{SRN: "PES1UG21CS001"}},
+	}
+	sessions.SessionsMutex.Unlock()
+	defer func() {
+		sessions.SessionsMutex.Lock()
+		delete(sessions.Sessions, sessionID)
+		sessions.SessionsMutex.Unlock()
+	}()
+
+	delegate := func(scope string, expiresAt time.Time) models.Delegation {
+		delegation := models.Delegation{DelegatorID: "T001", DelegateID: "TA001", SessionID: sessionID, Scope: scope, ExpiresAt: expiresAt}
+		if err := database.CreateDelegation(&delegation); err != nil {
+			t.Fatalf("failed to create delegation: %v", err)
+		}
+		return delegation
+	}
+	revoke := func(delegation models.Delegation) {
+		req := httptest.NewRequest("DELETE", fmt.Sprintf("/delegations/%d", delegation.ID), nil)
+		logIn(req, "T001")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("revoking got status %d: %s", w.Code, w.Body.String())
+		}
+	}
+	join := func() *client.Session {
+		opts := client.Options{BaseURL: server.URL, Session: auth.SignSession(testSessionSecret, "TA001", time.Now().Add(time.Hour))}
+		session, err := client.JoinSession(context.Background(), opts, sessionID)
+		if err != nil {
+			t.Fatalf("failed to join: %v", err)
+		}
+		return session
+	}
+	// nextResult skips attendance updates up to the next command result, or reports the connection closing
+	nextResult := func(session *client.Session) (client.CommandResult, bool) {
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case event, ok := <-session.Events():
+				if !ok {
+					return client.CommandResult{}, false
+				}
+				if result, isResult := event.(client.CommandResult); isResult {
+					return result, true
+				}
+			case <-timeout:
+				t.Fatal("timed out waiting for the server")
+			}
+		}
+	}
+
+	toggle := delegate(models.DelegationToggle, time.Now().Add(time.Hour))
+	view := delegate(models.DelegationView, time.Now().Add(time.Hour))
+	session := join()
+	defer session.Close()
+
+	session.Toggle("PES1UG21CS001", "", "")
+	if result, ok := nextResult(session); !ok || result.Status.Status != protocol.StatusOK {
+		t.Fatalf("toggle with a toggle delegation: got %+v, open %t", result, ok)
+	}
+	revoke(toggle)
+	session.Toggle("PES1UG21CS001", "", "")
+	if result, ok := nextResult(session); !ok || result.Status.Status != protocol.StatusError {
+		t.Errorf("toggle after the toggle delegation was revoked: got %+v, open %t", result, ok)
+	}
+	revoke(view)
+	if result, ok := nextResult(session); ok {
+		t.Errorf("still connected after every delegation was revoked, got %+v", result)
+	}
+
+	delegate(models.DelegationView, time.Now().Add(200*time.Millisecond))
+	expiring := join()
+	defer expiring.Close()
+	if result, ok := nextResult(expiring); ok {
+		t.Errorf("still connected after the delegation expired, got %+v", result)
+	}
+}
+
+func TestSpotChecksOutliveTheSession(t *testing.T) {
+	router := setupTestRouter(t)
+
+	const sessionID = 777
+	before, _ := sessionRecordsOf(sessionID)
+	sessions.SessionsMutex.Lock()
+	sessions.Sessions[sessionID] = models.Session{
+		Owner:           "T001",
+		ClassroomTables: []string{"CSE_A"},
+		Students:        []models.StudentInASession{{SRN: "PES1UG21CS001", IsPresent: true}, {SRN: "PES1UG21CS002", IsPresent: true}},
+		SpotChecks: []models.SpotCheck{
+			{ID: 1, Verified: []string{"PES1UG21CS001"}, Flagged: []string{"PES1UG21CS002"}, Completed: true},
+		},
+	}
+	sessions.SessionsMutex.Unlock()
+	if err := sessions.EndSession(sessionID); err != nil {
+		t.Fatalf("failed to end session: %v", err)
+	}
+
+	records, err := sessionRecordsOf(sessionID)
+	if err != nil || len(records) != len(before)+1 {
+		t.Fatalf("got %d new records, %v, want the ended session", len(records)-len(before), err)
+	}
+	req := httptest.NewRequest("GET", fmt.Sprintf("/reports/session-records/%d/spot-checks", records[len(records)-1].ID), nil)
+	logIn(req, "TA001")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var response struct {
+		SpotChecks []models.SpotCheckRecord `json:"spotChecks"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	if len(response.SpotChecks) != 1 || !slices.Equal(response.SpotChecks[0].Flagged, []string{"PES1UG21CS002"}) ||
+		!slices.Equal(response.SpotChecks[0].Verified, []string{"PES1UG21CS001"}) || !response.SpotChecks[0].Completed {
+		t.Errorf("got spot-checks %+v", response.SpotChecks)
+	}
+}
+
+func TestReportsKeepReusedSessionIDsApart(t *testing.T) {
+	router := setupTestRouter(t)
+
+	// two finished sessions an hour apart that drew the same ID
+	const sessionID = 780
+	now := time.Now()
+	var records []models.SessionRecord
+	for i, SRN := range []string{"PES1UG21CS001", "PES1UG21CS002"} {
+		startedAt := now.Add(time.Duration(i-2) * time.Hour)
+		record := models.SessionRecord{SessionID: sessionID, Owner: "T001", ClassroomTables: "CSE_A", StartedAt: startedAt, EndedAt: startedAt.Add(50 * time.Minute)}
+		if err := database.SaveSessionRecord(&record); err != nil {
+			t.Fatalf("failed to save record: %v", err)
+		}
+		records = append(records, record)
+		flag := models.AnomalyFlag{CreatedAt: startedAt.Add(10 * time.Minute), SessionID: sessionID, SRN: SRN, Rule: "SHARED_IP", Score: 0.6}
+		if err := database.CreateAnomalyFlag(&flag); err != nil {
+			t.Fatalf("failed to store flag: %v", err)
+		}
+		attempt := models.ScanAttempt{CreatedAt: startedAt.Add(5 * time.Minute), SessionID: sessionID, SRN: SRN, Outcome: models.ScanOutcomeAccepted}
+		if err := database.CreateScanAttempts([]models.ScanAttempt{attempt}); err != nil {
+			t.Fatalf("failed to store scan attempt: %v", err)
+		}
+		estimate := models.TeacherLatencyEstimate{CreatedAt: startedAt, SessionID: sessionID, Estimate: int64(100 * (i + 1))}
+		if err := database.CreateTeacherLatencyEstimates([]models.TeacherLatencyEstimate{estimate}); err != nil {
+			t.Fatalf("failed to store teacher latency: %v", err)
+		}
+	}
+
+	for i, want := range []string{"PES1UG21CS001", "PES1UG21CS002"} {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/reports/session-records/%d/anomalies", records[i].ID), nil)
+		logIn(req, "T001")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response struct {
+			Flags []models.AnomalyFlag `json:"flags"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+		}
+		if len(response.Flags) != 1 || response.Flags[0].SRN != want {
+			t.Errorf("record %d got flags %+v, want only %s's", i, response.Flags, want)
+		}
+
+		req = httptest.NewRequest("GET", fmt.Sprintf("/admin/scan-attempts?record=%d", records[i].ID), nil)
+		logIn(req, "A001")
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var audit struct {
+			Attempts       []models.ScanAttempt            `json:"attempts"`
+			TeacherLatency []models.TeacherLatencyEstimate `json:"teacherLatency"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &audit); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+		}
+		if len(audit.Attempts) != 1 || audit.Attempts[0].SRN != want {
+			t.Errorf("record %d got scan attempts %+v, want only %s's", i, audit.Attempts, want)
+		}
+		if len(audit.TeacherLatency) != 1 || audit.TeacherLatency[0].Estimate != int64(100*(i+1)) {
+			t.Errorf("record %d got teacher latency %+v, want only its own probe", i, audit.TeacherLatency)
+		}
+	}
+}
+
+func TestTimetableSlotsMatchTheirTeacherAcrossMidnight(t *testing.T) {
+	setupTestRouter(t)
+
+	// a little before midnight, another teacher is still in CSE_A and T001 is next
+	at := time.Date(2026, time.October, 19, 23, 58, 0, 0, time.Local)
+	tomorrow := at.AddDate(0, 0, 1)
+	for _, slot := range []models.TimetableSlot{
+		{Course: "UE21CS341", ClassroomTable: "CSE_A", Teacher: "T002", Weekday: at.Weekday(), StartTime: "23:00", EndTime: "23:59"},
+		{Course: "UE21CS342", ClassroomTable: "CSE_A", Teacher: "T001", Weekday: tomorrow.Weekday(), StartTime: "00:05", EndTime: "01:00"},
+	} {
+		if err := database.CreateTimetableSlot(&slot); err != nil {
+			t.Fatalf("failed to create slot: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		teacher  string
+		at       time.Time
+		want     string // course, empty for no match
+		wantDate time.Time
+	}{
+		{name: "own slot just after midnight", teacher: "T001", at: at, want: "UE21CS342", wantDate: tomorrow},
+		{name: "own slot before midnight", teacher: "T002", at: at, want: "UE21CS341", wantDate: at},
+		{name: "too early for the grace", teacher: "T001", at: at.Add(-10 * time.Minute)},
+		{name: "only other teachers' slots", teacher: "T003", at: at},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slot, date, err := database.FindTimetableSlot(tt.teacher, []string{"CSE_A"}, tt.at, 10*time.Minute)
+			if tt.want == "" {
+				if !errors.Is(err, gorm.ErrRecordNotFound) {
+					t.Errorf("got %s on %v, %v, want no slot", slot.Course, date, err)
+				}
+				return
+			}
+			if err != nil || slot.Course != tt.want || date.Format("2006-01-02") != tt.wantDate.Format("2006-01-02") {
+				t.Errorf("got %s on %v, %v, want %s on %v", slot.Course, date, err, tt.want, tt.wantDate)
+			}
+		})
+	}
+}
+
+func TestLockoutsFollowTheLoggedInStudent(t *testing.T) {
+	router := setupTestRouter(t)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	registerTestUser(t, "PES1UG21CS002")
+	sessions.SetRateLimits(sessions.RateLimits{LockoutAfter: 1, LockoutWindow: time.Minute, LockoutDuration: time.Minute})
+	defer sessions.SetRateLimits(sessions.DefaultRateLimits)
+
+	// PES1UG21CS002 scans garbage while claiming to be PES1UG21CS001
+	header := http.Header{
+		"Origin": {"http://localhost:3000"},
+		"Cookie": {(&http.Cookie{Name: auth.SessionCookie, Value: auth.SignSession(testSessionSecret, "PES1UG21CS002", time.Now().Add(time.Hour))}).String()},
+	}
+	dialer := websocket.Dialer{Subprotocols: []string{protocol.CurrentSubprotocol}}
+	ws, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/scan-qr", header)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	conn := protocol.NewConn(ws)
+	defer conn.Close()
+	for {
+		var ping protocol.TimePing
+		msgType, err := conn.Receive(&ping)
+		if err != nil {
+			t.Fatalf("clock sync failed: %v", err)
+		}
+		if msgType == protocol.TypeClockSynced {
+			break
+		}
+		now := time.Now().UnixMilli()
+		conn.Send(protocol.TypeTimePong, protocol.TimePong{ServerTime: ping.ServerTime, ClientReceivedAt: now, ClientSentAt: now})
+	}
+
+	// once locked out, they aim at someone else's session
+	const sessionID = 781
+	sessions.SessionsMutex.Lock()
+	sessions.Sessions[sessionID] = models.Session{Owner: "T001", ClassroomTables: []string{"CSE_A"}}
+	sessions.SessionsMutex.Unlock()
+	defer func() {
+		sessions.SessionsMutex.Lock()
+		delete(sessions.Sessions, sessionID)
+		sessions.SessionsMutex.Unlock()
+	}()
+	for _, scan := range []struct {
+		sessionID models.ID
+		want      string
+	}{
+		{1, sessions.ErrSessionNotFound.Code},
+		{sessionID, sessions.ErrLockedOut.Code},
+		{sessionID, sessions.ErrLockedOut.Code},
+	} {
+		conn.Send(protocol.TypeScan, models.ScanMessage{SessionID: scan.sessionID, ScannedRandomID: 1, ScannedAt: strconv.FormatInt(time.Now().UnixMilli(), 10), SRN: "PES1UG21CS001"})
+		var result protocol.Status
+		if _, err := conn.Receive(&result); err != nil || result.Code != scan.want {
+			t.Fatalf("got %+v, %v, want %s", result, err, scan.want)
+		}
+	}
+	sessions.SessionsMutex.Lock()
+	session := sessions.Sessions[sessionID]
+	sessions.SessionsMutex.Unlock()
+	if session.ScanAttempts != 0 || session.ScanFailures != 0 {
+		t.Errorf("locked out scans counted towards the session they claimed: %d attempts, %d failures", session.ScanAttempts, session.ScanFailures)
+	}
+
+	if err := sessions.AllowScan("PES1UG21CS001", "192.0.2.1", 1); err != nil {
+		t.Errorf("the claimed student got %v, want them unaffected", err)
+	}
+	if err := sessions.AllowScan("PES1UG21CS002", "192.0.2.1", 1); !errors.Is(err, sessions.ErrLockedOut) {
+		t.Errorf("the logged in student got %v, want %v", err, sessions.ErrLockedOut)
+	}
+}
+
+func TestRacingEndsPersistOnce(t *testing.T) {
+	setupTestRouter(t)
+
+	const sessionID = 778
+	before, _ := sessionRecordsOf(sessionID)
+	sessions.SessionsMutex.Lock()
+	sessions.Sessions[sessionID] = models.Session{Owner: "T001", ClassroomTables: []string{"CSE_A"}}
+	sessions.SessionsMutex.Unlock()
+
+	// the teacher's socket closing while an admin force-ends the session
+	errs := make(chan error, 2)
+	go func() { errs <- sessions.EndSession(sessionID) }()
+	go func() { errs <- sessions.ForceEndSession(sessionID, "A001") }()
+	failed := 0
+	for range 2 {
+		if err := <-errs; err != nil {
+			failed++
+		}
+	}
+
+	records, err := sessionRecordsOf(sessionID)
+	if err != nil {
+		t.Fatalf("failed to fetch records: %v", err)
+	}
+	if len(records)-len(before) != 1 || failed != 1 {
+		t.Errorf("got %d new records and %d failed ends, want 1 of each", len(records)-len(before), failed)
+	}
+}
+
+// sessionRecordsOf lists the records written for an ad hoc session, oldest first
+func sessionRecordsOf(sessionID models.ID) ([]models.SessionRecord, error) {
+	all, err := database.GetSessionRecords(0)
+	var records []models.SessionRecord
+	for _, record := range all {
+		if record.SessionID == sessionID {
+			records = append(records, record)
+		}
+	}
+	return records, err
+}
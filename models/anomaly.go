@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// AnomalyFlag is a student flagged by one of the anomaly rules as likely marked present by a proxy
+type AnomalyFlag struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"createdAt"`
+	SessionID ID        `json:"sessionID" gorm:"index"`
+	SRN       string    `json:"SRN" gorm:"index"`
+	ClientIP  string    `json:"clientIP"`
+	Rule      string    `json:"rule"`
+	Score     float64   `json:"score"` // 0 to 1, how sure the rule is
+	Reason    string    `json:"reason"`
+}
+
+// StudentAnomalies sums up the flags one student got in a session
+type StudentAnomalies struct {
+	SRN   string   `json:"SRN"`
+	Score float64  `json:"score"` // the highest of the student's flags
+	Rules []string `json:"rules"`
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Course is a subject, independent of who teaches it or when
+type Course struct {
+	gorm.Model
+	SubjectCode string `json:"subjectCode" gorm:"uniqueIndex"`
+	Name        string `json:"name"`
+}
+
+// CourseOffering is a course taught in a semester by some teachers to some classrooms
+type CourseOffering struct {
+	gorm.Model
+	CourseID   uint                      `json:"courseID"`
+	Course     Course                    `json:"course"`
+	Semester   string                    `json:"semester"`
+	Teachers   []CourseOfferingTeacher   `json:"teachers"`
+	Classrooms []CourseOfferingClassroom `json:"classrooms"`
+}
+
+type CourseOfferingTeacher struct {
+	ID               uint   `json:"-" gorm:"primarykey"`
+	CourseOfferingID uint   `json:"-" gorm:"index"`
+	TeacherID        string `json:"teacherID" gorm:"index"`
+}
+
+type CourseOfferingClassroom struct {
+	ID               uint   `json:"-" gorm:"primarykey"`
+	CourseOfferingID uint   `json:"-" gorm:"index"`
+	ClassroomTable   string `json:"classroomTable"`
+}
+
+// ClassroomTables returns the roster tables of the offering
+func (o CourseOffering) ClassroomTables() []string {
+	tables := make([]string, 0, len(o.Classrooms))
+	for _, classroom := range o.Classrooms {
+		tables = append(tables, classroom.ClassroomTable)
+	}
+	return tables
+}
+
+// HasTeacher reports whether teacherID teaches this offering
+func (o CourseOffering) HasTeacher(teacherID string) bool {
+	for _, teacher := range o.Teachers {
+		if teacher.TeacherID == teacherID {
+			return true
+		}
+	}
+	return false
+}
+
+// SessionRecord is a finished attendance session, persisted when the teacher's socket closes
+type SessionRecord struct {
+	ID               uint               `json:"id" gorm:"primarykey"`
+	SessionID        ID                 `json:"sessionID" gorm:"index"`
+	CourseOfferingID uint               `json:"courseOfferingID" gorm:"index"` // 0 for sessions opened directly on classrooms
+	Owner            string             `json:"owner"`
+	ClassroomTables  string             `json:"classroomTables"` // comma separated
+	TimetableSlotID  uint               `json:"timetableSlotID"`
+	StartedAt        time.Time          `json:"startedAt"`
+	EndedAt          time.Time          `json:"endedAt"`
+	Records          []AttendanceRecord `json:"records,omitempty"`
+	SpotChecks       []SpotCheckRecord  `json:"spotChecks,omitempty"`
+}
+
+// AttendanceRecord is one student's final status in a finished session
+type AttendanceRecord struct {
+	ID               uint   `json:"-" gorm:"primarykey"`
+	SessionRecordID  uint   `json:"sessionRecordID" gorm:"index"`
+	CourseOfferingID uint   `json:"courseOfferingID" gorm:"index"`
+	SRN              string `json:"SRN" gorm:"index"`
+	PRN              string `json:"PRN"`
+	Name             string `json:"name"`
+	Section          string `json:"section"`
+	IsPresent        bool   `json:"isPresent"`
+	IsGuest          bool   `json:"isGuest"`
+}
+
+// SpotCheckRecord is a spot-check of a finished session, as it stood when the session ended
+type SpotCheckRecord struct {
+	ID              uint      `json:"-" gorm:"primarykey"`
+	SessionRecordID uint      `json:"sessionRecordID" gorm:"index"`
+	SpotCheckID     int       `json:"spotCheckID"`
+	StartedAt       time.Time `json:"startedAt"`
+	EndsAt          time.Time `json:"endsAt"`
+	Pending         []string  `json:"pending" gorm:"serializer:json"` // still pending when the session ended early
+	Verified        []string  `json:"verified" gorm:"serializer:json"`
+	Flagged         []string  `json:"flagged" gorm:"serializer:json"`
+	Completed       bool      `json:"completed"`
+}
+
+// AttendanceSummary is how many of a course offering's sessions a student attended
+type AttendanceSummary struct {
+	CourseOfferingID uint    `json:"courseOfferingID"`
+	SubjectCode      string  `json:"subjectCode,omitempty"`
+	SRN              string  `json:"SRN"`
+	Name             string  `json:"name,omitempty"`
+	Attended         int     `json:"attended"`
+	Total            int     `json:"total"`
+	Percentage       float64 `json:"percentage"`
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql/driver"
 	"encoding/gob"
 	"errors"
+	"time"
 
 	"github.com/go-webauthn/webauthn/webauthn"
 	"gorm.io/gorm"
@@ -12,8 +13,9 @@ import (
 
 type User struct {
 	gorm.Model
-	SRN         string             `json:"SRN" gorm:"index"`
+	SRN         string             `json:"SRN" gorm:"index"` // staff accounts use their staff ID here
 	Credentials CredentialsWrapper `gorm:"type:blob"`
+	Roles       []Role             `json:"roles" gorm:"many2many:user_roles"`
 }
 
 // CredentialsWrapper wraps []webauthn.Credential for GORM
@@ -61,3 +63,17 @@ func (u User) WebAuthnDisplayName() string {
 func (u User) WebAuthnCredentials() []webauthn.Credential {
 	return u.Credentials
 }
+
+// AttendanceAudit is an append-only record of a manual change to a student's attendance.
+// It deliberately has no UpdatedAt or DeletedAt, rows are only ever inserted
+type AttendanceAudit struct {
+	ID         uint      `json:"id" gorm:"primarykey"`
+	CreatedAt  time.Time `json:"createdAt"`
+	SessionID  ID        `json:"sessionID" gorm:"index"`
+	SRN        string    `json:"SRN" gorm:"index"`
+	Actor      string    `json:"actor"`
+	OldStatus  bool      `json:"oldStatus"`
+	NewStatus  bool      `json:"newStatus"`
+	ReasonCode string    `json:"reasonCode"`
+	Comment    string    `json:"comment"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// geofence modes, how strictly a session's geofence is enforced
+const (
+	GeofenceReject = "reject" // scans from outside the fence, or without a location, are refused
+	GeofenceFlag   = "flag"   // they count, but the teacher is told
+	GeofenceIgnore = "ignore" // locations are only recorded for the scan audit
+)
+
+// where a scan was, relative to its session's geofence
+const (
+	LocationInside  = "INSIDE"
+	LocationOutside = "OUTSIDE"
+	LocationMissing = "MISSING" // the student's app sent no location
+)
+
+// Geofence is where a session's students are expected to scan from. A zero Radius means no fence
+type Geofence struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Radius    float64 `json:"radius"` // metres
+	Mode      string  `json:"mode"`
+}
+
+// ClassroomGeofence is the geofence of a classroom's sessions, unless the teacher passes their own
+type ClassroomGeofence struct {
+	ClassroomTable string    `json:"classroomTable" gorm:"primarykey"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+	Geofence       `gorm:"embedded"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// IDBits is the width of session and QR IDs. 53 bits is the most a JavaScript
+// number, or any float64 JSON decoder, holds exactly
+const IDBits = 53
+
+// MaxID is the largest valid ID
+const MaxID = 1<<IDBits - 1
+
+// ID identifies a live session or a QR. It is sent as a JSON number, and
+// accepted as a number or a decimal string
+type ID uint64
+
+// ParseID parses a decimal ID, as found in URLs and JSON strings
+func ParseID(raw string) (ID, error) {
+	parsed, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil || parsed > MaxID {
+		return 0, fmt.Errorf("invalid ID %q", raw)
+	}
+	return ID(parsed), nil
+}
+
+func (id ID) String() string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+func (id *ID) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	var raw json.Number
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("ID must be a number or a decimal string: %w", err)
+	}
+	parsed, err := ParseID(raw.String())
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
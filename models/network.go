@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// network restriction modes, how strictly a session's allowed networks are enforced
+const (
+	NetworkReject = "reject" // scans from outside the allowed networks are refused
+	NetworkFlag   = "flag"   // they count, but the teacher is told
+	NetworkIgnore = "ignore" // addresses are only checked for the scan audit
+)
+
+// where a scan came from, relative to its session's allowed networks
+const (
+	NetworkAllowed = "ALLOWED"
+	NetworkOutside = "OFF_NETWORK"
+)
+
+// NetworkRestriction is the client networks a session's students are expected to scan
+// from, like the campus Wi-Fi subnets. No CIDRs means no restriction
+type NetworkRestriction struct {
+	CIDRs []string `json:"cidrs" gorm:"serializer:json"`
+	Mode  string   `json:"mode"`
+}
+
+// ClassroomNetwork is the network restriction of a classroom's sessions, unless the teacher passes their own
+type ClassroomNetwork struct {
+	ClassroomTable     string    `json:"classroomTable" gorm:"primarykey"`
+	UpdatedAt          time.Time `json:"updatedAt"`
+	NetworkRestriction `gorm:"embedded"`
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// permissions a route can require
+const (
+	PermScanAttendance   = "attendance:scan"
+	PermCreateSession    = "session:create"
+	PermManageAnySession = "session:manage-any" // open sessions for offerings one doesn't teach
+	PermViewOwnReport    = "reports:view-own"
+	PermViewReports      = "reports:view"
+	PermExportReports    = "reports:export"
+	PermViewAudit        = "audit:view"
+	PermViewTimetable    = "timetable:view"
+	PermManageTimetable  = "timetable:manage"
+	PermViewCourses      = "courses:view"
+	PermManageCourses    = "courses:manage"
+	PermManageRoles      = "roles:manage"
+	PermDelegate         = "session:delegate" // hand courses or sessions to TAs
+	PermJoinSession      = "session:join"     // use delegated access to someone else's session
+	PermObserveSession   = "session:observe"  // watch any live session read-only, without a delegation
+	PermAdminSessions    = "session:admin"    // list and force-end every live session
+)
+
+// AllPermissions is every permission known to the server, seeded into the database on startup
+var AllPermissions = []string{
+	PermScanAttendance,
+	PermCreateSession,
+	PermManageAnySession,
+	PermViewOwnReport,
+	PermViewReports,
+	PermExportReports,
+	PermViewAudit,
+	PermViewTimetable,
+	PermManageTimetable,
+	PermViewCourses,
+	PermManageCourses,
+	PermManageRoles,
+	PermDelegate,
+	PermJoinSession,
+	PermObserveSession,
+	PermAdminSessions,
+}
+
+// built in roles
+const (
+	RoleStudent = "student"
+	RoleTA      = "ta"
+	RoleTeacher = "teacher"
+	RoleHOD     = "hod"
+	RoleAdmin   = "admin"
+)
+
+// DefaultRolePermissions is what the built in roles start with. Once seeded,
+// roles live in the database and can be changed by admins
+var DefaultRolePermissions = map[string][]string{
+	RoleStudent: {PermScanAttendance, PermViewOwnReport},
+	RoleTA:      {PermJoinSession, PermViewOwnReport, PermViewReports, PermViewCourses, PermViewTimetable},
+	RoleTeacher: {PermCreateSession, PermDelegate, PermJoinSession, PermViewOwnReport, PermViewReports, PermExportReports, PermViewCourses, PermViewTimetable},
+	RoleHOD:     {PermCreateSession, PermDelegate, PermJoinSession, PermObserveSession, PermViewOwnReport, PermViewReports, PermExportReports, PermViewAudit, PermViewCourses, PermViewTimetable},
+	RoleAdmin:   AllPermissions,
+}
+
+type Role struct {
+	gorm.Model
+	Name        string       `json:"name" gorm:"uniqueIndex"`
+	Permissions []Permission `json:"permissions" gorm:"many2many:role_permissions"`
+}
+
+type Permission struct {
+	ID   uint   `json:"-" gorm:"primarykey"`
+	Name string `json:"name" gorm:"uniqueIndex"`
+}
+
+// SeededGrant remembers that a default permission was given to a built in role once,
+// so it isn't handed back after an admin takes it away
+type SeededGrant struct {
+	Role       string `gorm:"primaryKey"`
+	Permission string `gorm:"primaryKey"`
+}
+
+// delegation scopes, from least to most powerful
+const (
+	DelegationView   = "view"   // watch live attendance
+	DelegationToggle = "toggle" // view, plus manual attendance changes
+	DelegationFull   = "full"   // everything the teacher can do, including running the QR
+)
+
+// Delegation lets a teacher hand a course offering or a single live session to a TA until it expires
+type Delegation struct {
+	gorm.Model
+	DelegatorID      string    `json:"delegatorID" gorm:"index"`
+	DelegateID       string    `json:"delegateID" gorm:"index"`
+	CourseOfferingID uint      `json:"courseOfferingID"` // set for course-wide delegations
+	SessionID        ID        `json:"sessionID"`        // set for a single live session
+	Scope            string    `json:"scope"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+}
@@ -1,8 +1,17 @@
 package models
 
 type ScanMessage struct {
-	SessionID       uint32 `json:"sessionID"`
-	ScannedRandomID uint32 `json:"scannedRandomID"`
-	ScannedAt       string `json:"scannedAt"` // this is later parsed to uint64. This is a string to avoid overflow
-	SRN             string `json:"SRN"`
+	SessionID       ID              `json:"sessionID"`
+	ScannedRandomID ID              `json:"scannedRandomID"`
+	ScannedAt       string          `json:"scannedAt"` // this is later parsed to uint64. This is a string to avoid overflow
+	SRN             string          `json:"SRN"`
+	Location        *DeviceLocation `json:"location,omitempty"` // nil if the app couldn't get one
+}
+
+// DeviceLocation is where the student's phone says it is. Accuracy is the radius of
+// uncertainty in metres, as the browser's geolocation API reports it
+type DeviceLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Accuracy  float64 `json:"accuracy"`
 }
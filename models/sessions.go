@@ -1,11 +1,61 @@
 package models
 
+import (
+	"fmt"
+	"time"
+)
+
+// SessionConfig describes what a new session covers
+type SessionConfig struct {
+	Owner            string
+	CourseOfferingID uint // 0 for sessions opened directly on classrooms
+	ClassroomTables  []string
+	IDBits           uint               // width of the session's IDs, narrower for clients that predate wide IDs
+	Geofence         Geofence           // zero to use the classroom's; a Mode alone overrides just the classroom's mode
+	Network          NetworkRestriction // likewise
+}
+
 type Session struct {
+	Owner                     string
+	CourseOfferingID          uint
+	StartedAt                 int64
+	TimetableSlotID           uint // 0 if the session doesn't cover a timetable slot
+	IDBits                    uint
 	CurrentRandomID           RandomID
 	PastRandomIDs             []RandomID
-	ClassroomTable            string
+	ClassroomTables           []string // more than one for combined lectures and electives
 	Students                  []StudentInASession
-	TeacherQRRenderingLatency int64
+	TeacherQRRenderingLatency int64 // smoothed over every latency probe so far
+	TeacherLatencyProbes      int
+	SpotChecks                []SpotCheck
+	PendingApprovals          []PendingApproval
+	ConnectedScanners         int // student sockets that have scanned this session and are still open
+	ScanAttempts              int
+	ScanFailures              int
+	Geofence                  Geofence
+	LocationOutside           int // scans from outside the geofence
+	LocationMissing           int // scans without a location, while there is a geofence
+	Network                   NetworkRestriction
+	OffNetwork                int // scans from outside the allowed networks
+}
+
+// SessionSummary is what the admin dashboard shows about a live session
+type SessionSummary struct {
+	SessionID         ID       `json:"sessionID"`
+	Owner             string   `json:"owner"`
+	CourseOfferingID  uint     `json:"courseOfferingID"`
+	ClassroomTables   []string `json:"classroomTables"`
+	StartedAt         int64    `json:"startedAt"`
+	Present           int      `json:"present"`
+	Absent            int      `json:"absent"`
+	PendingApprovals  int      `json:"pendingApprovals"`
+	ConnectedScanners int      `json:"connectedScanners"`
+	ScanAttempts      int      `json:"scanAttempts"`
+	ScanFailures      int      `json:"scanFailures"`
+	ScanFailureRate   float64  `json:"scanFailureRate"`
+	LocationOutside   int      `json:"locationOutside"`
+	LocationMissing   int      `json:"locationMissing"`
+	OffNetwork        int      `json:"offNetwork"`
 }
 
 type StudentInASession struct {
@@ -13,10 +63,125 @@ type StudentInASession struct {
 	SRN       string `json:"SRN"`
 	Name      string `json:"name"`
 	IsPresent bool   `json:"isPresent"`
+	Section   string `json:"section"` // classroom table the student came from
+	IsGuest   bool   `json:"isGuest"` // not on the roster, admitted by the teacher
+}
+
+// PendingApproval is a valid scan from a student who isn't on the session's roster
+type PendingApproval struct {
+	SRN         string `json:"SRN"`
+	RequestedAt int64  `json:"requestedAt"`
 }
 
 type RandomID struct {
-	ID        uint32
+	ID        ID
 	CreatedAt int64
 	ExpiredAt int64
 }
+
+// SpotCheck is a short re-verification round started by the teacher mid-class.
+// Students who were present when it started must re-scan before EndsAt, or they get flagged
+type SpotCheck struct {
+	ID        int      `json:"id"`
+	StartedAt int64    `json:"startedAt"`
+	EndsAt    int64    `json:"endsAt"`
+	Pending   []string `json:"pending"`  // SRNs yet to re-scan
+	Verified  []string `json:"verified"` // SRNs that re-scanned in time
+	Flagged   []string `json:"flagged"`  // SRNs that did not re-scan in time
+	Completed bool     `json:"completed"`
+}
+
+// reason codes a teacher can attach to a manual attendance change
+const (
+	ReasonDeviceIssue       = "DEVICE_ISSUE"
+	ReasonLateArrival       = "LATE_ARRIVAL"
+	ReasonTeacherCorrection = "TEACHER_CORRECTION"
+	ReasonMedical           = "MEDICAL"
+	ReasonOther             = "OTHER"
+)
+
+// ManualOverride describes who is changing attendance by hand and why
+type ManualOverride struct {
+	Actor      string
+	ReasonCode string
+	Comment    string
+}
+
+// Validate checks the reason code, if one was given
+func (o ManualOverride) Validate() error {
+	switch o.ReasonCode {
+	case "", ReasonDeviceIssue, ReasonLateArrival, ReasonTeacherCorrection, ReasonMedical, ReasonOther:
+		return nil
+	}
+	return fmt.Errorf("unknown reason code %q", o.ReasonCode)
+}
+
+// scan attempt outcomes
+const (
+	ScanOutcomeAccepted        = "ACCEPTED"
+	ScanOutcomeRejected        = "REJECTED"
+	ScanOutcomePendingApproval = "PENDING_APPROVAL"
+)
+
+// ScanAttempt is the audit record of one scan validation, whatever its outcome.
+// All timestamps are unix milliseconds, on the server's clock unless noted
+type ScanAttempt struct {
+	ID                uint      `json:"id" gorm:"primarykey"`
+	CreatedAt         time.Time `json:"createdAt" gorm:"index"`
+	SRN               string    `json:"SRN" gorm:"index"`
+	ClientIP          string    `json:"clientIP"`
+	ConnectedAt       int64     `json:"connectedAt"` // when the student's socket opened
+	SessionID         ID        `json:"sessionID" gorm:"index"`
+	ScannedRandomID   ID        `json:"scannedRandomID"`
+	TokenCreatedAt    int64     `json:"tokenCreatedAt"` // when the matched ID was generated, 0 if none matched
+	RawScannedAt      int64     `json:"rawScannedAt"`   // student's clock
+	AdjustedScannedAt int64     `json:"adjustedScannedAt"`
+	ClockDrift        int64     `json:"clockDrift"`
+	StudentLatency    int64     `json:"studentLatency"`
+	RoundTrip         int64     `json:"roundTrip"`
+	ClockSyncRounds   int       `json:"clockSyncRounds"` // 0 for clients that only send a single clock sample
+	TeacherLatency    int64     `json:"teacherLatency"`
+	ExtraTolerance    int64     `json:"extraTolerance"` // slack from the student's timing profile
+	ExpiryDelta       int64     `json:"expiryDelta"`    // adjusted scan time minus the matched ID's expiry, 0 if no ID matched
+	Geofence          string    `json:"geofence"`       // the session's geofence mode, empty if it has no fence
+	Latitude          float64   `json:"latitude"`
+	Longitude         float64   `json:"longitude"`
+	LocationAccuracy  float64   `json:"locationAccuracy"`
+	LocationDistance  float64   `json:"locationDistance"` // metres from the fence's centre
+	LocationResult    string    `json:"locationResult"`
+	Network           string    `json:"network"` // the session's network restriction mode, empty if it has none
+	NetworkResult     string    `json:"networkResult"`
+	SpotCheckID       int       `json:"spotCheckID"` // the spot-check the scan re-verified the student for, 0 if none
+	Outcome           string    `json:"outcome" gorm:"index"`
+	FailureReason     string    `json:"failureReason"` // a sessions.ScanError code
+}
+
+// TeacherLatencyEstimate is one latency probe of the teacher's screen and the smoothed
+// estimate scans were adjusted by from then on. All values are in ms
+type TeacherLatencyEstimate struct {
+	ID            uint      `json:"id" gorm:"primarykey"`
+	CreatedAt     time.Time `json:"createdAt" gorm:"index"`
+	SessionID     ID        `json:"sessionID" gorm:"index"`
+	Probe         int       `json:"probe"` // 0 for the probe at connect time
+	RoundTrip     int64     `json:"roundTrip"`
+	RenderLatency int64     `json:"renderLatency"` // as reported by the teacher's client
+	Sample        int64     `json:"sample"`        // what this probe measured, capped
+	Estimate      int64     `json:"estimate"`
+}
+
+// StudentTimingProfile is how a student's scans have been timed across sessions. Every
+// average is smoothed over the scans that matched a QR, most recent weighing the most.
+// Values are in ms
+type StudentTimingProfile struct {
+	SRN              string    `json:"SRN" gorm:"primarykey"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+	Scans            int       `json:"scans"`
+	LateScans        int       `json:"lateScans"` // rejected as expired
+	Sessions         int       `json:"sessions"`  // distinct sessions the scans came from
+	LastSessionID    ID        `json:"-"`
+	ClockDrift       int64     `json:"clockDrift"`
+	ClockDriftJitter int64     `json:"clockDriftJitter"` // mean distance from the average drift
+	StudentLatency   int64     `json:"studentLatency"`
+	ExpiryDelta      int64     `json:"expiryDelta"`
+	ExtraTolerance   int64     `json:"extraTolerance" gorm:"index"` // added to the scan window of this student
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TimetableSlot is a recurring weekly class. Times are "15:04" in the server's local time
+type TimetableSlot struct {
+	gorm.Model
+	Course         string       `json:"course"`
+	ClassroomTable string       `json:"classroomTable" gorm:"index"`
+	Teacher        string       `json:"teacher"`
+	Weekday        time.Weekday `json:"weekday"` // 0 is Sunday
+	StartTime      string       `json:"startTime"`
+	EndTime        string       `json:"endTime"`
+	Room           string       `json:"room"`
+}
+
+// SlotOccurrence is a dated instance of a timetable slot, either held by a session or missed
+type SlotOccurrence struct {
+	ID        uint          `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time     `json:"createdAt"`
+	SlotID    uint          `json:"slotID" gorm:"uniqueIndex:idx_slot_date"`
+	Slot      TimetableSlot `json:"slot"`
+	Date      string        `json:"date" gorm:"uniqueIndex:idx_slot_date"` // "2006-01-02"
+	SessionID ID            `json:"sessionID"`
+	Held      bool          `json:"held"`
+}
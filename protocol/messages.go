@@ -0,0 +1,116 @@
+package protocol
+
+import "github.com/anuragrao04/qr-attendance-backend/models"
+
+// server to teacher, delegate and observer
+const (
+	TypeLatencyProbe     = "LATENCY_PROBE"     // payload models.RandomID, a dummy at connect time and the next QR from then on (v5)
+	TypeSessionStarted   = "SESSION_STARTED"   // payload SessionStarted
+	TypeRandomID         = "RANDOM_ID"         // payload models.RandomID, the QR to show next
+	TypeAttendanceUpdate = "ATTENDANCE_UPDATE" // payload AttendanceUpdate
+	TypeCommandResult    = "COMMAND_RESULT"    // payload Status, the reply to a teacher command
+	TypeError            = "ERROR"             // payload Status, sent before the server closes the socket
+)
+
+// Session events (SPOT_CHECK_STARTED, UNROSTERED_SCAN, SESSION_ENDED, ...) are forwarded
+// under their sessions.Event* type with the payload the sessions package attached
+
+// teacher to server
+const (
+	TypeLatencyProbeAck = "LATENCY_PROBE_ACK" // no payload needed, answers TypeLatencyProbe straight away
+	TypeRenderLatency   = "RENDER_LATENCY"    // payload RenderLatency, once the probe QR is on screen
+
+	// commands, payload TeacherCommand
+	TypeToggleAttendance  = "TOGGLE_ATTENDANCE"
+	TypeMarkAllPresent    = "MARK_ALL_PRESENT"
+	TypeMarkAllAbsent     = "MARK_ALL_ABSENT"
+	TypeMarkSRNs          = "MARK_SRNS"
+	TypeMarkSRNRange      = "MARK_SRN_RANGE"
+	TypeApproveUnrostered = "APPROVE_UNROSTERED"
+	TypeRejectUnrostered  = "REJECT_UNROSTERED"
+	TypeStartSpotCheck    = "START_SPOT_CHECK"
+)
+
+// student to server
+const (
+	TypeClockSync = "CLOCK_SYNC" // payload ClockSync, first message after connecting, before v4
+	TypeTimePong  = "TIME_PONG"  // payload TimePong, answers TypeTimePing straight away
+	TypeScan      = "SCAN"       // payload models.ScanMessage
+)
+
+// server to student
+const (
+	TypeTimePing    = "TIME_PING"    // payload TimePing, from v4
+	TypeClockSynced = "CLOCK_SYNCED" // payload ClockSynced, once the first ping rounds are done
+	TypeScanResult  = "SCAN_RESULT"  // payload Status
+)
+
+// statuses of a Status payload
+const (
+	StatusOK      = "OK"
+	StatusError   = "error"
+	StatusPending = "pending"
+)
+
+// Status is the outcome of a command or a scan. Code is set on failures of student scans
+type Status struct {
+	Status  string `json:"status"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+// SessionStarted is the first frame of a session socket, with the whole roster
+type SessionStarted struct {
+	SessionID models.ID                  `json:"sessionID"`
+	Students  []models.StudentInASession `json:"students"`
+}
+
+// AttendanceUpdate is the full attendance list, each half sorted by roll number
+type AttendanceUpdate struct {
+	Absentees  []models.StudentInASession `json:"absentees"`
+	Presentees []models.StudentInASession `json:"presentees"`
+}
+
+// RenderLatency is how long the teacher's client took to render the last probe QR
+type RenderLatency struct {
+	Message int64 `json:"message"` // milliseconds
+}
+
+// TeacherCommand is any command the teacher, or a delegate, sends. Which fields
+// matter depends on the command type
+type TeacherCommand struct {
+	SRN        string   `json:"srn"`
+	SRNs       []string `json:"srns"`
+	From       int      `json:"from"` // SRN suffix range, inclusive
+	To         int      `json:"to"`
+	IsPresent  bool     `json:"isPresent"`
+	ReasonCode string   `json:"reasonCode"`
+	Comment    string   `json:"comment"`
+	Duration   int64    `json:"duration"` // spot-check duration in seconds
+}
+
+// ClockSync lets the server estimate the student's clock drift
+type ClockSync struct {
+	ClientTime string `json:"clientTime"` // Unix timestamp in milliseconds
+}
+
+// TimePing is one clock sync round. All times are Unix milliseconds
+type TimePing struct {
+	ServerTime int64 `json:"serverTime"`
+	Round      int   `json:"round"`
+}
+
+// TimePong answers a TimePing, with the client's clock read when the ping
+// arrived and again just before answering
+type TimePong struct {
+	ServerTime       int64 `json:"serverTime"` // echoed from the ping, pongs to no ping are dropped
+	ClientReceivedAt int64 `json:"clientReceivedAt"`
+	ClientSentAt     int64 `json:"clientSentAt"`
+}
+
+// ClockSynced tells the student what the server measured
+type ClockSynced struct {
+	ClockDrift int64 `json:"clockDrift"` // server clock minus the student's clock, ms
+	RoundTrip  int64 `json:"roundTrip"`  // ms
+	Rounds     int   `json:"rounds"`
+}
@@ -0,0 +1,165 @@
+// Package protocol defines the messages exchanged over the teacher, delegate, observer
+// and student websockets, and how they are framed on the wire.
+//
+// The version is negotiated at connect time through the websocket subprotocol. Clients
+// that offer SubprotocolV2 or later get every frame wrapped in an Envelope. Clients that
+// offer nothing, or only SubprotocolV1, keep getting the original unwrapped frames, so
+// the frontend can move over whenever it is ready.
+//
+// From v3 on, session and QR IDs may use all 53 bits of models.ID, and may be sent as
+// numbers or decimal strings. Sessions opened by older teacher clients keep 32 bit IDs.
+//
+// From v4 on, the student socket opens with TIME_PING rounds instead of a single
+// CLOCK_SYNC, and the server keeps pinging now and then while the socket is open.
+//
+// From v5 on, the teacher socket gets a LATENCY_PROBE every so often during the session,
+// carrying a real QR in place of a RANDOM_ID. The client answers it like the probe at
+// connect time, so the server can follow the screen's render latency through the class.
+package protocol
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// protocol versions
+const (
+	Version1 = 1 // legacy, unwrapped frames
+	Version2 = 2 // enveloped frames
+	Version3 = 3 // enveloped frames, 53 bit IDs
+	Version4 = 4 // enveloped frames, 53 bit IDs, ping based clock sync
+	Version5 = 5 // v4, plus latency probes during the session
+
+	CurrentVersion = Version5
+)
+
+// websocket subprotocols a client may offer
+const (
+	SubprotocolV1 = "qr-attendance.v1"
+	SubprotocolV2 = "qr-attendance.v2"
+	SubprotocolV3 = "qr-attendance.v3"
+	SubprotocolV4 = "qr-attendance.v4"
+	SubprotocolV5 = "qr-attendance.v5"
+
+	CurrentSubprotocol = SubprotocolV5
+)
+
+// Subprotocols is what the server accepts, most preferred first. Pass it to the websocket upgrader
+var Subprotocols = []string{CurrentSubprotocol, SubprotocolV4, SubprotocolV3, SubprotocolV2, SubprotocolV1}
+
+// Envelope is the frame of every v2 message, in both directions. Seq counts the frames
+// each side has sent on the connection, starting at 1. The payload is what v1 sent
+// on its own, minus the type for the messages that already carried one
+type Envelope struct {
+	Type    string          `json:"type"`
+	Version int             `json:"version"`
+	Seq     uint64          `json:"seq"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Conn frames messages for the version negotiated on a websocket. Writes are
+// serialised, so one Conn can be shared between goroutines
+type Conn struct {
+	ws      *websocket.Conn
+	version int
+
+	writeMutex sync.Mutex
+	seq        uint64
+}
+
+// NewConn wraps an upgraded websocket, picking the version from its subprotocol
+func NewConn(ws *websocket.Conn) *Conn {
+	version := Version1
+	switch ws.Subprotocol() {
+	case SubprotocolV2:
+		version = Version2
+	case SubprotocolV3:
+		version = Version3
+	case SubprotocolV4:
+		version = Version4
+	case SubprotocolV5:
+		version = Version5
+	}
+	return &Conn{ws: ws, version: version}
+}
+
+// Version is the protocol version negotiated for the connection
+func (c *Conn) Version() int {
+	return c.version
+}
+
+// Close closes the underlying websocket
+func (c *Conn) Close() error {
+	return c.ws.Close()
+}
+
+// Send writes one message of the given type
+func (c *Conn) Send(msgType string, payload interface{}) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if c.version == Version1 {
+		return c.ws.WriteJSON(legacyFrame(msgType, payload))
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	c.seq++
+	return c.ws.WriteJSON(Envelope{Type: msgType, Version: c.version, Seq: c.seq, Payload: raw})
+}
+
+// Receive reads one message into payload and returns its type. v1 frames carry their
+// type inline, if at all, so for them the type may be empty
+func (c *Conn) Receive(payload interface{}) (string, error) {
+	_, raw, err := c.ws.ReadMessage()
+	if err != nil {
+		return "", err
+	}
+
+	if c.version == Version1 {
+		var typed struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(raw, &typed); err != nil {
+			return "", err
+		}
+		return typed.Type, json.Unmarshal(raw, payload)
+	}
+
+	var envelope Envelope
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return "", err
+	}
+	if len(envelope.Payload) == 0 {
+		return envelope.Type, nil
+	}
+	return envelope.Type, json.Unmarshal(envelope.Payload, payload)
+}
+
+// legacyFrame shapes a message the way v1 clients expect it
+func legacyFrame(msgType string, payload interface{}) interface{} {
+	switch msgType {
+	case TypeLatencyProbe, TypeRandomID, TypeSessionStarted, TypeCommandResult, TypeError, TypeScanResult:
+		return payload
+	case TypeAttendanceUpdate:
+		update, _ := payload.(AttendanceUpdate)
+		return legacyAttendanceUpdate{Type: msgType, AttendanceUpdate: update}
+	default:
+		// session events already had a type and payload
+		return legacyEvent{Type: msgType, Payload: payload}
+	}
+}
+
+type legacyAttendanceUpdate struct {
+	Type string `json:"type"`
+	AttendanceUpdate
+}
+
+type legacyEvent struct {
+	Type    string      `json:"type"`
+	Payload interface{} `json:"payload"`
+}
@@ -0,0 +1,152 @@
+package sessions
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/database"
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+// Access is what a connection may do with a live session
+type Access int
+
+const (
+	AccessNone   Access = iota
+	AccessView          // attendance snapshot and updates
+	AccessToggle        // view, plus manual attendance changes
+	AccessFull          // everything the owning teacher can do
+)
+
+// AccessFromScope maps a delegation scope to the access it grants
+func AccessFromScope(scope string) Access {
+	switch scope {
+	case models.DelegationView:
+		return AccessView
+	case models.DelegationToggle:
+		return AccessToggle
+	case models.DelegationFull:
+		return AccessFull
+	}
+	return AccessNone
+}
+
+// ValidDelegationScope reports whether scope is one of the known delegation scopes
+func ValidDelegationScope(scope string) bool {
+	return AccessFromScope(scope) != AccessNone
+}
+
+// SessionOwner returns the identity of whoever opened a live session
+func SessionOwner(sessionID models.ID) (string, error) {
+	SessionsMutex.Lock()
+	defer SessionsMutex.Unlock()
+
+	session, exists := Sessions[sessionID]
+	if !exists {
+		return "", fmt.Errorf("session %d not found", sessionID)
+	}
+	return session.Owner, nil
+}
+
+// SessionAccess works out what identity may do with a live session: its owner and the
+// teachers of its course offering have full control, anyone else needs an active delegation
+func SessionAccess(identity string, sessionID models.ID) (Access, error) {
+	access, _, err := SessionAccessUntil(identity, sessionID)
+	return access, err
+}
+
+// SessionAccessUntil is SessionAccess plus when the access has to be worked out again,
+// the earliest expiry among the delegations it comes from. Zero if it doesn't expire
+func SessionAccessUntil(identity string, sessionID models.ID) (Access, time.Time, error) {
+	SessionsMutex.Lock()
+	session, exists := Sessions[sessionID]
+	SessionsMutex.Unlock()
+	if !exists {
+		return AccessNone, time.Time{}, fmt.Errorf("session %d not found", sessionID)
+	}
+
+	if session.Owner == identity {
+		return AccessFull, time.Time{}, nil
+	}
+
+	if session.CourseOfferingID != 0 {
+		offering, err := database.GetCourseOffering(session.CourseOfferingID)
+		if err != nil {
+			return AccessNone, time.Time{}, err
+		}
+		if offering.HasTeacher(identity) {
+			return AccessFull, time.Time{}, nil
+		}
+	}
+
+	return delegatedAccess(identity, session.CourseOfferingID, sessionID)
+}
+
+// DelegatedAccess returns the widest access granted by the delegate's active
+// delegations for the course offering or the session
+func DelegatedAccess(delegateID string, courseOfferingID uint, sessionID models.ID) (Access, error) {
+	access, _, err := delegatedAccess(delegateID, courseOfferingID, sessionID)
+	return access, err
+}
+
+func delegatedAccess(delegateID string, courseOfferingID uint, sessionID models.ID) (Access, time.Time, error) {
+	delegations, err := database.GetDelegationsFor(delegateID, courseOfferingID, sessionID)
+	if err != nil {
+		return AccessNone, time.Time{}, err
+	}
+
+	access := AccessNone
+	var until time.Time
+	for _, delegation := range delegations {
+		access = max(access, AccessFromScope(delegation.Scope))
+		if until.IsZero() || delegation.ExpiresAt.Before(until) {
+			until = delegation.ExpiresAt
+		}
+	}
+	return access, until, nil
+}
+
+var (
+	delegationWatchers      = make(map[string]map[chan struct{}]struct{})
+	delegationWatchersMutex sync.Mutex
+)
+
+// WatchDelegations returns a channel that is signalled whenever the delegations
+// given to delegateID change, so their connections can work out their access again
+func WatchDelegations(delegateID string) chan struct{} {
+	delegationWatchersMutex.Lock()
+	defer delegationWatchersMutex.Unlock()
+
+	ch := make(chan struct{}, 1)
+	if delegationWatchers[delegateID] == nil {
+		delegationWatchers[delegateID] = make(map[chan struct{}]struct{})
+	}
+	delegationWatchers[delegateID][ch] = struct{}{}
+	return ch
+}
+
+// StopWatchingDelegations removes a channel returned by WatchDelegations
+func StopWatchingDelegations(delegateID string, ch chan struct{}) {
+	delegationWatchersMutex.Lock()
+	defer delegationWatchersMutex.Unlock()
+
+	delete(delegationWatchers[delegateID], ch)
+	if len(delegationWatchers[delegateID]) == 0 {
+		delete(delegationWatchers, delegateID)
+	}
+}
+
+// DelegationsChanged wakes everyone watching the delegations of delegateID
+func DelegationsChanged(delegateID string) {
+	delegationWatchersMutex.Lock()
+	defer delegationWatchersMutex.Unlock()
+
+	for ch := range delegationWatchers[delegateID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// already woken, it reads the delegations afresh anyway
+		}
+	}
+}
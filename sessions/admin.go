@@ -0,0 +1,98 @@
+package sessions
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+// EventSessionEnded tells everyone watching a session that it was closed from outside
+const EventSessionEnded = "SESSION_ENDED"
+
+// SessionEnded is the payload of an EventSessionEnded event
+type SessionEnded struct {
+	EndedBy string `json:"endedBy"`
+	EndedAt int64  `json:"endedAt"`
+}
+
+// ScannerConnected counts a student socket that started scanning the session
+func ScannerConnected(sessionID models.ID) {
+	SessionsMutex.Lock()
+	defer SessionsMutex.Unlock()
+
+	if session, exists := Sessions[sessionID]; exists {
+		session.ConnectedScanners++
+		Sessions[sessionID] = session
+	}
+}
+
+// ScannerDisconnected undoes ScannerConnected when the student socket closes
+func ScannerDisconnected(sessionID models.ID) {
+	SessionsMutex.Lock()
+	defer SessionsMutex.Unlock()
+
+	if session, exists := Sessions[sessionID]; exists && session.ConnectedScanners > 0 {
+		session.ConnectedScanners--
+		Sessions[sessionID] = session
+	}
+}
+
+// ListSessions summarises every live session, oldest first
+func ListSessions() []models.SessionSummary {
+	SessionsMutex.Lock()
+	defer SessionsMutex.Unlock()
+
+	summaries := make([]models.SessionSummary, 0, len(Sessions))
+	for sessionID, session := range Sessions {
+		summary := models.SessionSummary{
+			SessionID:         sessionID,
+			Owner:             session.Owner,
+			CourseOfferingID:  session.CourseOfferingID,
+			ClassroomTables:   session.ClassroomTables,
+			StartedAt:         session.StartedAt,
+			PendingApprovals:  len(session.PendingApprovals),
+			ConnectedScanners: session.ConnectedScanners,
+			ScanAttempts:      session.ScanAttempts,
+			ScanFailures:      session.ScanFailures,
+			LocationOutside:   session.LocationOutside,
+			LocationMissing:   session.LocationMissing,
+			OffNetwork:        session.OffNetwork,
+		}
+		for _, student := range session.Students {
+			if student.IsPresent {
+				summary.Present++
+			} else {
+				summary.Absent++
+			}
+		}
+		if session.ScanAttempts > 0 {
+			summary.ScanFailureRate = float64(session.ScanFailures) / float64(session.ScanAttempts)
+		}
+		summaries = append(summaries, summary)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].StartedAt < summaries[j].StartedAt })
+	return summaries
+}
+
+// ForceEndSession ends a live session from outside: it persists the final state, tells
+// the teacher and everyone watching, and closes their sockets by closing their channels
+func ForceEndSession(sessionID models.ID, endedBy string) error {
+	if _, err := SessionOwner(sessionID); err != nil {
+		return err
+	}
+
+	// sent before the channels close, so listeners drain it first
+	notifySessionEvent(sessionID, EventSessionEnded, SessionEnded{EndedBy: endedBy, EndedAt: Now().UnixMilli()})
+
+	if err := EndSession(sessionID); errors.Is(err, ErrSessionNotFound) {
+		return err // the teacher ended it first, and their call persisted it
+	} else if err != nil {
+		return fmt.Errorf("session %d ended but could not be persisted: %w", sessionID, err)
+	}
+	log.Printf("Session %d force-ended by %s", sessionID, endedBy)
+	return nil
+}
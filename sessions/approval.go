@@ -0,0 +1,131 @@
+package sessions
+
+import (
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+const (
+	EventUnrosteredScan     = "UNROSTERED_SCAN"
+	EventUnrosteredResolved = "UNROSTERED_RESOLVED"
+)
+
+// UnrosteredResolution is the payload of an EventUnrosteredResolved event
+type UnrosteredResolution struct {
+	SRN      string `json:"SRN"`
+	Approved bool   `json:"approved"`
+}
+
+// decision channels of students waiting for the teacher, guarded by SessionsMutex
+var pendingDecisions = make(map[models.ID]map[string]chan bool) // SessionID -> SRN -> decision
+
+// QueueUnrosteredScan puts a validated scan from an unrostered student in front of the teacher.
+// The returned channel receives the teacher's decision, and is closed without one if the
+// request is superseded or the session ends
+func QueueUnrosteredScan(sessionID models.ID, srn string) (<-chan bool, error) {
+	SessionsMutex.Lock()
+	defer SessionsMutex.Unlock()
+
+	session, exists := Sessions[sessionID]
+	if !exists {
+		return nil, ErrSessionNotFound
+	}
+
+	if pendingDecisions[sessionID] == nil {
+		pendingDecisions[sessionID] = make(map[string]chan bool)
+	}
+
+	// a student reconnecting while still pending replaces their old request
+	if old, exists := pendingDecisions[sessionID][srn]; exists {
+		close(old)
+	}
+	decision := make(chan bool, 1)
+	pendingDecisions[sessionID][srn] = decision
+
+	pending := models.PendingApproval{SRN: srn, RequestedAt: Now().UnixMilli()}
+	session.PendingApprovals = slices.DeleteFunc(session.PendingApprovals, func(p models.PendingApproval) bool { return p.SRN == srn })
+	session.PendingApprovals = append(session.PendingApprovals, pending)
+	Sessions[sessionID] = session
+
+	log.Printf("SRN %s queued for approval in session %d", srn, sessionID)
+	go notifySessionEvent(sessionID, EventUnrosteredScan, pending)
+	return decision, nil
+}
+
+// CancelUnrosteredScan withdraws a pending request, for example when the student gives up waiting
+func CancelUnrosteredScan(sessionID models.ID, srn string, decision <-chan bool) {
+	SessionsMutex.Lock()
+	defer SessionsMutex.Unlock()
+
+	current, exists := pendingDecisions[sessionID][srn]
+	if !exists || current != decision {
+		return
+	}
+	close(current)
+	delete(pendingDecisions[sessionID], srn)
+
+	session, exists := Sessions[sessionID]
+	if !exists {
+		return
+	}
+	session.PendingApprovals = slices.DeleteFunc(session.PendingApprovals, func(p models.PendingApproval) bool { return p.SRN == srn })
+	Sessions[sessionID] = session
+	go notifySessionEvent(sessionID, EventUnrosteredResolved, UnrosteredResolution{SRN: srn, Approved: false})
+}
+
+// ResolveUnrosteredScan approves or rejects a pending scan. Approved students are added as present guests
+func ResolveUnrosteredScan(sessionID models.ID, srn string, approve bool, override models.ManualOverride) error {
+	if err := override.Validate(); err != nil {
+		return err
+	}
+
+	SessionsMutex.Lock()
+
+	session, exists := Sessions[sessionID]
+	if !exists {
+		SessionsMutex.Unlock()
+		return fmt.Errorf("session %d not found", sessionID)
+	}
+
+	decision, exists := pendingDecisions[sessionID][srn]
+	if !exists {
+		SessionsMutex.Unlock()
+		return fmt.Errorf("no pending approval for SRN %s in session %d", srn, sessionID)
+	}
+	delete(pendingDecisions[sessionID], srn)
+	session.PendingApprovals = slices.DeleteFunc(session.PendingApprovals, func(p models.PendingApproval) bool { return p.SRN == srn })
+
+	if approve && !slices.ContainsFunc(session.Students, func(s models.StudentInASession) bool { return s.SRN == srn }) {
+		session.Students = append(session.Students, models.StudentInASession{
+			SRN:       srn,
+			IsPresent: true,
+			IsGuest:   true,
+		})
+	}
+
+	Sessions[sessionID] = session
+	SessionsMutex.Unlock()
+
+	decision <- approve
+	close(decision)
+
+	log.Printf("SRN %s approved=%t in session %d", srn, approve, sessionID)
+	go notifySessionEvent(sessionID, EventUnrosteredResolved, UnrosteredResolution{SRN: srn, Approved: approve})
+	if !approve {
+		return nil
+	}
+
+	go notifyAttendanceChange(sessionID)
+	return recordOverrides(sessionID, override, []attendanceChange{{SRN: srn, OldStatus: false, NewStatus: true}})
+}
+
+// releasePendingDecisions closes every decision channel of a session. Callers must hold SessionsMutex
+func releasePendingDecisions(sessionID models.ID) {
+	for _, decision := range pendingDecisions[sessionID] {
+		close(decision)
+	}
+	delete(pendingDecisions, sessionID)
+}
@@ -0,0 +1,40 @@
+package sessions
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/anuragrao04/qr-attendance-backend/database"
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+// attendanceChange is a single student's status flip, waiting to be audited
+type attendanceChange struct {
+	SRN       string
+	OldStatus bool
+	NewStatus bool
+}
+
+// recordOverrides writes one audit entry per changed student.
+// Call it after releasing SessionsMutex, it hits the database
+func recordOverrides(sessionID models.ID, override models.ManualOverride, changes []attendanceChange) error {
+	entries := make([]models.AttendanceAudit, 0, len(changes))
+	for _, change := range changes {
+		entries = append(entries, models.AttendanceAudit{
+			SessionID:  sessionID,
+			SRN:        change.SRN,
+			Actor:      override.Actor,
+			OldStatus:  change.OldStatus,
+			NewStatus:  change.NewStatus,
+			ReasonCode: override.ReasonCode,
+			Comment:    override.Comment,
+		})
+	}
+
+	err := database.CreateAttendanceAudits(entries)
+	if err != nil {
+		log.Printf("Failed to write %d audit entries for session %d: %v", len(entries), sessionID, err)
+		return fmt.Errorf("attendance changed but the audit entry could not be saved: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package sessions
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+// Clock tells the time to everything timing sensitive in sessions and the socket handlers
+type Clock interface {
+	Now() time.Time
+}
+
+// TokenSource supplies the randomness behind session and QR IDs. It must be
+// unpredictable, since knowing the next QR is as good as seeing it
+type TokenSource interface {
+	Uint64() uint64
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+type cryptoSource struct{}
+
+func (cryptoSource) Uint64() uint64 {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+var (
+	clock       Clock       = systemClock{}
+	tokenSource TokenSource = cryptoSource{}
+	clockMutex  sync.RWMutex
+)
+
+// RandomIDLifetime is how long a QR stays on screen before the next one replaces it
+const RandomIDLifetime = 200 * time.Millisecond
+
+// LegacyIDBits is the ID width of sessions whose teacher speaks a protocol older than v3
+const LegacyIDBits = 32
+
+// SetClock replaces the clock, for tests. Pass nil to go back to the system clock
+func SetClock(c Clock) {
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+	if c == nil {
+		c = systemClock{}
+	}
+	clock = c
+}
+
+// SetTokenSource replaces the randomness, for tests. Pass nil to go back to crypto/rand
+func SetTokenSource(s TokenSource) {
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+	if s == nil {
+		s = cryptoSource{}
+	}
+	tokenSource = s
+}
+
+// Now is the current time on the sessions clock
+func Now() time.Time {
+	clockMutex.RLock()
+	defer clockMutex.RUnlock()
+	return clock.Now()
+}
+
+// allocateID draws nonzero IDs of the given width until one isn't taken
+func allocateID(bits uint, taken func(models.ID) bool) models.ID {
+	if bits == 0 || bits > models.IDBits {
+		bits = models.IDBits
+	}
+	mask := uint64(1)<<bits - 1
+
+	clockMutex.RLock()
+	source := tokenSource
+	clockMutex.RUnlock()
+
+	for {
+		id := models.ID(source.Uint64() & mask)
+		if id != 0 && !taken(id) {
+			return id
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package sessions
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+)
+
+// ScanContext is what the server knows about a student's connection when it validates their scans
+type ScanContext struct {
+	ClockDrift     int64 // server clock minus the student's clock, ms
+	StudentLatency int64 // one-way network latency, ms
+	RoundTrip      int64 // ms
+	Samples        int   // clock sync rounds behind the estimate, 0 for a legacy single sample
+	ExtraTolerance int64 // from the student's timing profile, ms
+}
+
+// how many recent rounds a ClockSync keeps, and how many unanswered pings it waits on
+const clockSyncWindow = 16
+
+// MaxRoundTrip is the most round trip, and half of it the most latency, a scan is credited
+// with, in ms. Slower answers come from a stalled phone, or one holding its pongs back
+const MaxRoundTrip = 1000
+
+type clockSample struct {
+	drift     int64
+	roundTrip int64
+}
+
+// ClockSync estimates a student's clock drift and latency from NTP-style ping rounds.
+// It is safe to ping from one goroutine while another takes the pongs and estimates
+type ClockSync struct {
+	mutex   sync.Mutex
+	samples []clockSample
+	pending []int64 // send times of the pings not answered yet, oldest first
+}
+
+// Ping records a ping stamped serverSent as sent. Its stamp is its nonce: only a
+// pong echoing it is measured. Call it before sending the ping
+func (s *ClockSync) Ping(serverSent int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pending = append(s.pending, serverSent)
+	if len(s.pending) > clockSyncWindow {
+		s.pending = s.pending[len(s.pending)-clockSyncWindow:]
+	}
+}
+
+// Pong records one round: the server sent a ping at serverSent, the student received it
+// at clientReceived and answered at clientSent, both on their clock, and the answer
+// arrived at serverReceived. A serverSent that isn't a ping still waiting for its answer,
+// made up or answered before, is dropped and false returned
+func (s *ClockSync) Pong(serverSent, clientReceived, clientSent, serverReceived int64) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i := slices.Index(s.pending, serverSent)
+	if i < 0 {
+		return false
+	}
+	s.pending = slices.Delete(s.pending, i, i+1)
+
+	elapsed := serverReceived - serverSent
+	hold := clientSent - clientReceived
+	if hold < 0 || hold > elapsed {
+		// the student misreported how long it held the ping
+		hold = 0
+	}
+	roundTrip := elapsed - hold
+	drift := ((serverSent - clientReceived) + (serverReceived - clientSent)) / 2
+
+	s.samples = append(s.samples, clockSample{drift: drift, roundTrip: roundTrip})
+	if len(s.samples) > clockSyncWindow {
+		s.samples = s.samples[len(s.samples)-clockSyncWindow:]
+	}
+	return true
+}
+
+// Estimate trusts the half of the rounds with the shortest round trips, since those
+// queued the least and their drift is the most accurate, and takes their medians
+func (s *ClockSync) Estimate() ScanContext {
+	s.mutex.Lock()
+	samples := slices.Clone(s.samples)
+	s.mutex.Unlock()
+
+	if len(samples) == 0 {
+		return ScanContext{}
+	}
+
+	slices.SortFunc(samples, func(a, b clockSample) int { return cmp.Compare(a.roundTrip, b.roundTrip) })
+	best := samples[:(len(samples)+1)/2]
+
+	roundTrips := make([]int64, len(best))
+	drifts := make([]int64, len(best))
+	for i, sample := range best {
+		roundTrips[i] = sample.roundTrip
+		drifts[i] = sample.drift
+	}
+	roundTrip := median(roundTrips)
+
+	return ScanContext{
+		ClockDrift:     median(drifts),
+		StudentLatency: roundTrip / 2,
+		RoundTrip:      roundTrip,
+		Samples:        len(samples),
+	}
+}
+
+// median of the values, the lower middle one for an even count
+func median(values []int64) int64 {
+	slices.Sort(values)
+	return values[(len(values)-1)/2]
+}
@@ -0,0 +1,111 @@
+package sessions
+
+import "testing"
+
+// round is one simulated ping: up and down are the one-way delays, hold is how long
+// the phone took to answer, all in ms
+type round struct {
+	up, hold, down int64
+}
+
+func TestClockSyncEstimate(t *testing.T) {
+	const drift = 5000 // the phone is 5s behind
+
+	tests := []struct {
+		name          string
+		rounds        []round
+		wantDrift     int64
+		wantRoundTrip int64
+	}{
+		{
+			name:          "single symmetric round",
+			rounds:        []round{{up: 10, hold: 2, down: 10}},
+			wantDrift:     drift,
+			wantRoundTrip: 20,
+		},
+		{
+			name:          "phone hold time is not latency",
+			rounds:        []round{{up: 10, hold: 300, down: 10}},
+			wantDrift:     drift,
+			wantRoundTrip: 20,
+		},
+		{
+			name: "congested rounds are rejected",
+			rounds: []round{
+				{up: 10, hold: 1, down: 10},
+				{up: 10, hold: 1, down: 900}, // a stalled answer would pull the drift by 445ms
+				{up: 12, hold: 1, down: 12},
+				{up: 600, hold: 1, down: 10},
+				{up: 11, hold: 1, down: 11},
+			},
+			wantDrift:     drift,
+			wantRoundTrip: 22,
+		},
+		{
+			name: "asymmetric paths bias the drift by half the difference",
+			rounds: []round{
+				{up: 30, hold: 1, down: 10},
+			},
+			wantDrift:     drift - 10,
+			wantRoundTrip: 40,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var clockSync ClockSync
+			serverSent := int64(1_700_000_000_000)
+			for _, r := range tt.rounds {
+				clientReceived := serverSent + r.up - drift
+				clientSent := clientReceived + r.hold
+				serverReceived := serverSent + r.up + r.hold + r.down
+				clockSync.Ping(serverSent)
+				clockSync.Pong(serverSent, clientReceived, clientSent, serverReceived)
+				serverSent += 1000
+			}
+
+			got := clockSync.Estimate()
+			if got.ClockDrift != tt.wantDrift {
+				t.Errorf("got drift %d, want %d", got.ClockDrift, tt.wantDrift)
+			}
+			if got.RoundTrip != tt.wantRoundTrip {
+				t.Errorf("got round trip %d, want %d", got.RoundTrip, tt.wantRoundTrip)
+			}
+			if got.StudentLatency != tt.wantRoundTrip/2 {
+				t.Errorf("got latency %d, want %d", got.StudentLatency, tt.wantRoundTrip/2)
+			}
+			if got.Samples != len(tt.rounds) {
+				t.Errorf("got %d samples, want %d", got.Samples, len(tt.rounds))
+			}
+		})
+	}
+}
+
+func TestClockSyncOnlyMeasuresPings(t *testing.T) {
+	const sent = int64(1_700_000_000_000)
+	var clockSync ClockSync
+	clockSync.Ping(sent)
+	clockSync.Ping(sent + 30_000)
+
+	pongs := []struct {
+		name                                                   string
+		serverSent, clientReceived, clientSent, serverReceived int64
+		want                                                   bool
+	}{
+		{"made up stamp inflating the round trip", sent - 60_000, sent, sent, sent + 20, false},
+		{"older ping answered late", sent, sent + 10, sent + 10, sent + 30_010, true},
+		{"same ping answered again", sent, sent + 10, sent + 10, sent + 30_020, false},
+		{"newer ping, hold claimed negative", sent + 30_000, sent + 30_010, sent + 20_000, sent + 30_020, true},
+	}
+	for _, pong := range pongs {
+		if got := clockSync.Pong(pong.serverSent, pong.clientReceived, pong.clientSent, pong.serverReceived); got != pong.want {
+			t.Errorf("%s: got %t, want %t", pong.name, got, pong.want)
+		}
+	}
+
+	// the negative hold counts as none, so its round trip is what the server saw
+	got := clockSync.Estimate()
+	if got.Samples != 2 || got.RoundTrip != 20 {
+		t.Errorf("got %d samples, round trip %d, want 2 samples, round trip 20", got.Samples, got.RoundTrip)
+	}
+}
@@ -0,0 +1,44 @@
+package sessions
+
+import "errors"
+
+// ScanError is a failure reported to a scanning student. Code is stable and meant
+// for clients to switch on; Message is for showing to the student
+type ScanError struct {
+	Code    string
+	Message string
+}
+
+func (e *ScanError) Error() string {
+	return e.Message
+}
+
+// the scan failure catalog. Codes must never change once shipped
+var (
+	ErrInvalidMessage       = &ScanError{"INVALID_MESSAGE", "The app sent a message the server couldn't read. Please update or reload the app"}
+	ErrSessionNotFound      = &ScanError{"SESSION_NOT_FOUND", "This attendance session doesn't exist or has already ended"}
+	ErrAlreadyPresent       = &ScanError{"ALREADY_PRESENT", "You are already marked present for this session"}
+	ErrTokenExpired         = &ScanError{"TOKEN_EXPIRED", "This QR code has expired. Scan the one currently on screen"}
+	ErrTokenUnknown         = &ScanError{"TOKEN_UNKNOWN", "This QR code doesn't belong to this session"}
+	ErrTokenBeforeSpotCheck = &ScanError{"TOKEN_BEFORE_SPOT_CHECK", "This QR code was shown before the spot-check started. Scan the one currently on screen"}
+	ErrSpotCheckNotPending  = &ScanError{"SPOT_CHECK_NOT_PENDING", "You have no pending spot-check for this session"}
+	ErrNotInRoster          = &ScanError{"NOT_IN_ROSTER", "You are not on this class's roster"}
+	ErrApprovalRejected     = &ScanError{"APPROVAL_REJECTED", "The teacher rejected your attendance request"}
+	ErrApprovalWithdrawn    = &ScanError{"APPROVAL_WITHDRAWN", "Your approval request was withdrawn or the session ended"}
+	ErrApprovalTimeout      = &ScanError{"APPROVAL_TIMEOUT", "The teacher did not respond to your attendance request"}
+	ErrRateLimited          = &ScanError{"RATE_LIMITED", "Too many scan attempts. Wait a moment and try again"}
+	ErrLockedOut            = &ScanError{"LOCKED_OUT", "Too many failed scans. You can try again in a few minutes"}
+	ErrOutsideGeofence      = &ScanError{"OUTSIDE_GEOFENCE", "You don't seem to be in the classroom. Scan from inside it, with location turned on"}
+	ErrLocationRequired     = &ScanError{"LOCATION_REQUIRED", "This session needs your location. Allow location access and scan again"}
+	ErrOffNetwork           = &ScanError{"OFF_NETWORK", "Connect to the campus Wi-Fi to mark attendance"}
+	ErrInternal             = &ScanError{"INTERNAL_ERROR", "Something went wrong on our side. Please try again"}
+)
+
+// AsScanError finds the ScanError in err's chain. Anything unexpected becomes ErrInternal
+func AsScanError(err error) *ScanError {
+	var scanErr *ScanError
+	if errors.As(err, &scanErr) {
+		return scanErr
+	}
+	return ErrInternal
+}
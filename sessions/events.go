@@ -0,0 +1,86 @@
+package sessions
+
+import (
+	"log"
+	"sync"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+// session event types pushed to the teacher alongside attendance updates
+const (
+	EventSpotCheckStarted = "SPOT_CHECK_STARTED"
+	EventSpotCheckUpdate  = "SPOT_CHECK_UPDATE"
+	EventSpotCheckResult  = "SPOT_CHECK_RESULT"
+)
+
+// SessionEvent is a typed notification for a session that isn't an attendance list change
+type SessionEvent struct {
+	SessionID models.ID
+	Type      string
+	Payload   interface{}
+}
+
+var (
+	sessionEventListeners = make(map[models.ID]map[chan SessionEvent]struct{})
+	eventListenersMutex   sync.Mutex
+)
+
+// RegisterForSessionEvents creates and returns a channel that will receive
+// session events for the specified session
+func RegisterForSessionEvents(sessionID models.ID) chan SessionEvent {
+	eventListenersMutex.Lock()
+	defer eventListenersMutex.Unlock()
+
+	ch := make(chan SessionEvent, 10)
+	if sessionEventListeners[sessionID] == nil {
+		sessionEventListeners[sessionID] = make(map[chan SessionEvent]struct{})
+	}
+	sessionEventListeners[sessionID][ch] = struct{}{}
+	return ch
+}
+
+// UnregisterFromSessionEvents removes one listener's session event channel
+func UnregisterFromSessionEvents(sessionID models.ID, ch chan SessionEvent) {
+	eventListenersMutex.Lock()
+	defer eventListenersMutex.Unlock()
+
+	if _, exists := sessionEventListeners[sessionID][ch]; exists {
+		close(ch)
+		delete(sessionEventListeners[sessionID], ch)
+	}
+	if len(sessionEventListeners[sessionID]) == 0 {
+		delete(sessionEventListeners, sessionID)
+	}
+}
+
+// closeSessionEventListeners closes every listener of an ended session
+func closeSessionEventListeners(sessionID models.ID) {
+	eventListenersMutex.Lock()
+	defer eventListenersMutex.Unlock()
+
+	for ch := range sessionEventListeners[sessionID] {
+		close(ch)
+	}
+	delete(sessionEventListeners, sessionID)
+}
+
+// PublishSessionEvent sends a session event to every listener, for the subsystems
+// outside this package that report on live sessions
+func PublishSessionEvent(sessionID models.ID, eventType string, payload interface{}) {
+	notifySessionEvent(sessionID, eventType, payload)
+}
+
+// notifySessionEvent sends a session event to every registered listener
+func notifySessionEvent(sessionID models.ID, eventType string, payload interface{}) {
+	eventListenersMutex.Lock()
+	defer eventListenersMutex.Unlock()
+
+	for ch := range sessionEventListeners[sessionID] {
+		select {
+		case ch <- SessionEvent{SessionID: sessionID, Type: eventType, Payload: payload}:
+		default:
+			log.Printf("Session event channel for session %d is full, dropping %s", sessionID, eventType)
+		}
+	}
+}
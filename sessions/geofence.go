@@ -0,0 +1,135 @@
+package sessions
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/anuragrao04/qr-attendance-backend/database"
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+// EventLocationFailure tells the teacher a scan came from outside the geofence, or without a location
+const EventLocationFailure = "LOCATION_FAILURE"
+
+// LocationFailure is the payload of an EventLocationFailure event, along with the session's counts so far
+type LocationFailure struct {
+	SRN      string  `json:"SRN"`
+	Result   string  `json:"result"`
+	Distance float64 `json:"distance"` // metres from the fence's centre, 0 without a location
+	Accepted bool    `json:"accepted"`
+	Outside  int     `json:"outside"`
+	Missing  int     `json:"missing"`
+}
+
+// DefaultGeofenceMode is the mode of a geofence that doesn't name one
+const DefaultGeofenceMode = models.GeofenceFlag
+
+const earthRadius = 6_371_000 // metres
+
+// ValidateGeofence checks a geofence's centre, radius and mode, filling in the default mode
+func ValidateGeofence(fence *models.Geofence) error {
+	if fence.Latitude < -90 || fence.Latitude > 90 || fence.Longitude < -180 || fence.Longitude > 180 {
+		return errors.New("latitude must be within -90 and 90, and longitude within -180 and 180")
+	}
+	if fence.Radius <= 0 {
+		return errors.New("radius must be positive")
+	}
+	if fence.Mode == "" {
+		fence.Mode = DefaultGeofenceMode
+	}
+	return ValidateGeofenceMode(fence.Mode)
+}
+
+// ValidateGeofenceMode checks mode is one of the geofence modes
+func ValidateGeofenceMode(mode string) error {
+	switch mode {
+	case models.GeofenceReject, models.GeofenceFlag, models.GeofenceIgnore:
+		return nil
+	}
+	return fmt.Errorf("geofence mode must be %s, %s or %s", models.GeofenceReject, models.GeofenceFlag, models.GeofenceIgnore)
+}
+
+// resolveGeofence picks a new session's geofence: the one in its config, else the first
+// of its classrooms'. A config with just a mode keeps the classroom's fence under that mode
+func resolveGeofence(config models.SessionConfig) (models.Geofence, error) {
+	if config.Geofence.Radius > 0 {
+		fence := config.Geofence
+		return fence, ValidateGeofence(&fence)
+	}
+
+	classroomFence, found, err := database.GetClassroomGeofence(config.ClassroomTables)
+	if err != nil || !found {
+		return models.Geofence{}, err
+	}
+	fence := classroomFence.Geofence
+	if config.Geofence.Mode != "" {
+		fence.Mode = config.Geofence.Mode
+	}
+	return fence, ValidateGeofence(&fence)
+}
+
+// locateScan records where a scan was relative to the session's geofence. A fix no more
+// accurate than the fence is wide is given the benefit of the doubt only up to the fence's radius
+func locateScan(fence models.Geofence, location *models.DeviceLocation, attempt *models.ScanAttempt) {
+	if fence.Radius <= 0 {
+		return
+	}
+	attempt.Geofence = fence.Mode
+	if location == nil || !validLocation(*location) {
+		attempt.LocationResult = models.LocationMissing
+		return
+	}
+
+	attempt.Latitude = location.Latitude
+	attempt.Longitude = location.Longitude
+	attempt.LocationAccuracy = location.Accuracy
+	attempt.LocationDistance = math.Round(distance(fence.Latitude, fence.Longitude, location.Latitude, location.Longitude))
+	if attempt.LocationDistance-min(location.Accuracy, fence.Radius) > fence.Radius {
+		attempt.LocationResult = models.LocationOutside
+	} else {
+		attempt.LocationResult = models.LocationInside
+	}
+}
+
+// enforceGeofence refuses scans from outside a rejecting geofence
+func enforceGeofence(attempt models.ScanAttempt) error {
+	if attempt.Geofence != models.GeofenceReject {
+		return nil
+	}
+	switch attempt.LocationResult {
+	case models.LocationOutside:
+		return ErrOutsideGeofence
+	case models.LocationMissing:
+		return ErrLocationRequired
+	}
+	return nil
+}
+
+// isLocationFailure reports whether a scan counts towards the teacher's location failures.
+// Under an ignoring geofence nothing does, and neither do scans refused for their QR
+func isLocationFailure(attempt models.ScanAttempt) bool {
+	if attempt.Geofence == "" || attempt.Geofence == models.GeofenceIgnore {
+		return false
+	}
+	if attempt.Outcome == models.ScanOutcomeRejected &&
+		attempt.FailureReason != ErrOutsideGeofence.Code && attempt.FailureReason != ErrLocationRequired.Code {
+		return false
+	}
+	return attempt.LocationResult == models.LocationOutside || attempt.LocationResult == models.LocationMissing
+}
+
+func validLocation(location models.DeviceLocation) bool {
+	return location.Latitude >= -90 && location.Latitude <= 90 &&
+		location.Longitude >= -180 && location.Longitude <= 180 &&
+		location.Accuracy >= 0 && !math.IsNaN(location.Accuracy) &&
+		(location.Latitude != 0 || location.Longitude != 0)
+}
+
+// distance is the great-circle distance between two points, in metres
+func distance(lat1, lng1, lat2, lng2 float64) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+	dLat, dLng := toRadians(lat2-lat1), toRadians(lng2-lng1)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(min(h, 1)))
+}
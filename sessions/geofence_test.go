@@ -0,0 +1,109 @@
+package sessions
+
+import (
+	"testing"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+// a 50 m fence; a thousandth of a degree of latitude is about 111 m
+var testFence = models.Geofence{Latitude: 12.9345, Longitude: 77.5345, Radius: 50, Mode: models.GeofenceReject}
+
+// metresNorth is a location the given distance north of the test fence's centre
+func metresNorth(metres, accuracy float64) *models.DeviceLocation {
+	return &models.DeviceLocation{Latitude: testFence.Latitude + metres/111_195, Longitude: testFence.Longitude, Accuracy: accuracy}
+}
+
+func TestLocateScan(t *testing.T) {
+	tests := []struct {
+		name         string
+		location     *models.DeviceLocation
+		want         string
+		wantDistance float64
+	}{
+		{name: "in the room", location: metresNorth(10, 5), want: models.LocationInside, wantDistance: 10},
+		{name: "at the edge", location: metresNorth(50, 0), want: models.LocationInside, wantDistance: 50},
+		{name: "just outside", location: metresNorth(51, 0), want: models.LocationOutside, wantDistance: 51},
+		{name: "outside, but the fix is fuzzy enough", location: metresNorth(80, 30), want: models.LocationInside, wantDistance: 80},
+		{name: "fuzziness only counts up to the radius", location: metresNorth(101, 5000), want: models.LocationOutside, wantDistance: 101},
+		{name: "across town", location: metresNorth(5000, 20), want: models.LocationOutside, wantDistance: 5000},
+		{name: "no location", want: models.LocationMissing},
+		{name: "nonsense location", location: &models.DeviceLocation{Latitude: 120, Longitude: 77.5345}, want: models.LocationMissing},
+		{name: "null island", location: &models.DeviceLocation{}, want: models.LocationMissing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempt models.ScanAttempt
+			locateScan(testFence, tt.location, &attempt)
+			if attempt.LocationResult != tt.want || attempt.LocationDistance != tt.wantDistance {
+				t.Errorf("got %s at %.0f m, want %s at %.0f m", attempt.LocationResult, attempt.LocationDistance, tt.want, tt.wantDistance)
+			}
+			if attempt.Geofence != models.GeofenceReject {
+				t.Errorf("got geofence mode %q recorded, want %q", attempt.Geofence, models.GeofenceReject)
+			}
+		})
+	}
+
+	var attempt models.ScanAttempt
+	locateScan(models.Geofence{}, metresNorth(10, 5), &attempt)
+	if attempt.LocationResult != "" || attempt.Geofence != "" {
+		t.Errorf("a session without a fence located the scan: %+v", attempt)
+	}
+}
+
+func TestValidateScanGeofence(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		randomID models.ID
+		location *models.DeviceLocation
+		wantErr  error
+		counted  bool // towards the teacher's location failures
+	}{
+		{name: "inside", mode: models.GeofenceReject, randomID: 3, location: metresNorth(10, 5)},
+		{name: "outside a rejecting fence", mode: models.GeofenceReject, randomID: 3, location: metresNorth(500, 5), wantErr: ErrOutsideGeofence, counted: true},
+		{name: "no location for a rejecting fence", mode: models.GeofenceReject, randomID: 3, wantErr: ErrLocationRequired, counted: true},
+		{name: "bad QR is reported before the location", mode: models.GeofenceReject, randomID: 99, location: metresNorth(500, 5), wantErr: ErrTokenUnknown},
+		{name: "outside a flagging fence", mode: models.GeofenceFlag, randomID: 3, location: metresNorth(500, 5), counted: true},
+		{name: "outside an ignoring fence", mode: models.GeofenceIgnore, randomID: 3, location: metresNorth(500, 5)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := startRestrictedTestSession(t, func(session *models.Session) {
+				session.Geofence = testFence
+				session.Geofence.Mode = tt.mode
+			})
+			attempt, session := validateAndRecord(t, tt.randomID, tt.location, "", tt.wantErr)
+			if counted := session.LocationOutside+session.LocationMissing == 1; counted != tt.counted {
+				t.Errorf("got %d outside and %d missing, want counted %t", session.LocationOutside, session.LocationMissing, tt.counted)
+			}
+			if !tt.counted {
+				return
+			}
+
+			failure, ok := expectEvent(t, events, EventLocationFailure).(LocationFailure)
+			if !ok || failure.Result != attempt.LocationResult || failure.Accepted != (tt.wantErr == nil) || failure.Outside+failure.Missing != 1 {
+				t.Errorf("got %+v for a %s scan", failure, attempt.LocationResult)
+			}
+		})
+	}
+}
+
+func TestValidateGeofence(t *testing.T) {
+	fence := models.Geofence{Latitude: 12.9, Longitude: 77.5, Radius: 40}
+	if err := ValidateGeofence(&fence); err != nil || fence.Mode != DefaultGeofenceMode {
+		t.Errorf("got %v and mode %q, want the default mode %q", err, fence.Mode, DefaultGeofenceMode)
+	}
+	for _, bad := range []models.Geofence{
+		{Latitude: 91, Longitude: 77.5, Radius: 40},
+		{Latitude: 12.9, Longitude: -181, Radius: 40},
+		{Latitude: 12.9, Longitude: 77.5},
+		{Latitude: 12.9, Longitude: 77.5, Radius: 40, Mode: "warn"},
+	} {
+		if err := ValidateGeofence(&bad); err == nil {
+			t.Errorf("%+v should not validate", bad)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package sessions
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/anuragrao04/qr-attendance-backend/database"
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+// EventOffNetwork tells the teacher a scan came from outside the session's allowed networks
+const EventOffNetwork = "OFF_NETWORK_SCAN"
+
+// OffNetworkScan is the payload of an EventOffNetwork event, along with the session's count so far
+type OffNetworkScan struct {
+	SRN        string `json:"SRN"`
+	ClientIP   string `json:"clientIP"`
+	Accepted   bool   `json:"accepted"`
+	OffNetwork int    `json:"offNetwork"`
+}
+
+// DefaultNetworkMode is the mode of a network restriction that doesn't name one
+const DefaultNetworkMode = models.NetworkFlag
+
+// ValidateNetworkMode checks mode is one of the network restriction modes
+func ValidateNetworkMode(mode string) error {
+	switch mode {
+	case models.NetworkReject, models.NetworkFlag, models.NetworkIgnore:
+		return nil
+	}
+	return fmt.Errorf("network mode must be %s, %s or %s", models.NetworkReject, models.NetworkFlag, models.NetworkIgnore)
+}
+
+// ValidateNetworkRestriction checks a restriction's CIDRs and mode, filling in the default
+// mode. Bare addresses are taken as single host ranges, and every range is normalised
+func ValidateNetworkRestriction(restriction *models.NetworkRestriction) error {
+	if len(restriction.CIDRs) == 0 {
+		return errors.New("at least one CIDR is required")
+	}
+	cidrs := make([]string, 0, len(restriction.CIDRs))
+	for _, raw := range restriction.CIDRs {
+		prefix, err := parsePrefix(raw)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q", raw)
+		}
+		cidrs = append(cidrs, prefix.String())
+	}
+	restriction.CIDRs = cidrs
+	if restriction.Mode == "" {
+		restriction.Mode = DefaultNetworkMode
+	}
+	return ValidateNetworkMode(restriction.Mode)
+}
+
+func parsePrefix(raw string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(raw); err == nil {
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(raw)
+	return prefix.Masked(), err
+}
+
+// resolveNetworkRestriction picks a new session's network restriction the way resolveGeofence
+// picks its geofence
+func resolveNetworkRestriction(config models.SessionConfig) (models.NetworkRestriction, error) {
+	if len(config.Network.CIDRs) > 0 {
+		restriction := config.Network
+		return restriction, ValidateNetworkRestriction(&restriction)
+	}
+
+	classroomNetwork, found, err := database.GetClassroomNetwork(config.ClassroomTables)
+	if err != nil || !found {
+		return models.NetworkRestriction{}, err
+	}
+	restriction := classroomNetwork.NetworkRestriction
+	if config.Network.Mode != "" {
+		restriction.Mode = config.Network.Mode
+	}
+	return restriction, ValidateNetworkRestriction(&restriction)
+}
+
+// checkNetwork records whether a scan came from one of the session's allowed networks.
+// An address that doesn't parse is outside all of them
+func checkNetwork(restriction models.NetworkRestriction, attempt *models.ScanAttempt) {
+	if len(restriction.CIDRs) == 0 {
+		return
+	}
+	attempt.Network = restriction.Mode
+	attempt.NetworkResult = models.NetworkOutside
+
+	addr, err := netip.ParseAddr(attempt.ClientIP)
+	if err != nil {
+		return
+	}
+	addr = addr.Unmap()
+	for _, cidr := range restriction.CIDRs {
+		if prefix, err := netip.ParsePrefix(cidr); err == nil && prefix.Contains(addr) {
+			attempt.NetworkResult = models.NetworkAllowed
+			return
+		}
+	}
+}
+
+// enforceNetwork refuses scans from outside a rejecting network restriction
+func enforceNetwork(attempt models.ScanAttempt) error {
+	if attempt.Network == models.NetworkReject && attempt.NetworkResult == models.NetworkOutside {
+		return ErrOffNetwork
+	}
+	return nil
+}
+
+// isOffNetwork reports whether a scan counts towards the teacher's off network scans,
+// on the same terms as isLocationFailure
+func isOffNetwork(attempt models.ScanAttempt) bool {
+	if attempt.Network == "" || attempt.Network == models.NetworkIgnore {
+		return false
+	}
+	if attempt.Outcome == models.ScanOutcomeRejected && attempt.FailureReason != ErrOffNetwork.Code {
+		return false
+	}
+	return attempt.NetworkResult == models.NetworkOutside
+}
@@ -0,0 +1,86 @@
+package sessions
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+func TestValidateNetworkRestriction(t *testing.T) {
+	restriction := models.NetworkRestriction{CIDRs: []string{"10.20.30.40/16", "192.168.1.7", "::ffff:172.16.0.1", "2001:db8::/32"}}
+	if err := ValidateNetworkRestriction(&restriction); err != nil {
+		t.Fatalf("failed to validate: %v", err)
+	}
+	want := []string{"10.20.0.0/16", "192.168.1.7/32", "172.16.0.1/32", "2001:db8::/32"}
+	if !slices.Equal(restriction.CIDRs, want) {
+		t.Errorf("got %v, want %v", restriction.CIDRs, want)
+	}
+	if restriction.Mode != DefaultNetworkMode {
+		t.Errorf("got mode %q, want the default %q", restriction.Mode, DefaultNetworkMode)
+	}
+
+	for _, bad := range []models.NetworkRestriction{
+		{},
+		{CIDRs: []string{"10.0.0.0/33"}},
+		{CIDRs: []string{"campus"}},
+		{CIDRs: []string{"10.0.0.0/8"}, Mode: "warn"},
+	} {
+		if err := ValidateNetworkRestriction(&bad); err == nil {
+			t.Errorf("%+v should not validate", bad)
+		}
+	}
+}
+
+func TestValidateScanNetwork(t *testing.T) {
+	campus := []string{"10.20.0.0/16", "2001:db8::/32"}
+	tests := []struct {
+		name     string
+		mode     string
+		clientIP string
+		randomID models.ID
+		want     string
+		wantErr  error
+		counted  bool // towards the teacher's off network scans
+	}{
+		{name: "campus Wi-Fi", mode: models.NetworkReject, clientIP: "10.20.5.9", randomID: 3, want: models.NetworkAllowed},
+		{name: "campus over IPv6", mode: models.NetworkReject, clientIP: "2001:db8::42", randomID: 3, want: models.NetworkAllowed},
+		{name: "campus address mapped into IPv6", mode: models.NetworkReject, clientIP: "::ffff:10.20.5.9", randomID: 3, want: models.NetworkAllowed},
+		{name: "mobile data, rejected", mode: models.NetworkReject, clientIP: "49.36.1.2", randomID: 3, want: models.NetworkOutside, wantErr: ErrOffNetwork, counted: true},
+		{name: "no address, rejected", mode: models.NetworkReject, clientIP: "", randomID: 3, want: models.NetworkOutside, wantErr: ErrOffNetwork, counted: true},
+		{name: "bad QR is reported before the network", mode: models.NetworkReject, clientIP: "49.36.1.2", randomID: 99, want: models.NetworkOutside, wantErr: ErrTokenUnknown},
+		{name: "mobile data, flagged", mode: models.NetworkFlag, clientIP: "49.36.1.2", randomID: 3, want: models.NetworkOutside, counted: true},
+		{name: "mobile data, ignored", mode: models.NetworkIgnore, clientIP: "49.36.1.2", randomID: 3, want: models.NetworkOutside},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := startRestrictedTestSession(t, func(session *models.Session) {
+				session.Network = models.NetworkRestriction{CIDRs: campus, Mode: tt.mode}
+			})
+			attempt, session := validateAndRecord(t, tt.randomID, nil, tt.clientIP, tt.wantErr)
+			if attempt.NetworkResult != tt.want || attempt.Network != tt.mode || attempt.ClientIP != tt.clientIP {
+				t.Errorf("got %s under %q from %q, want %s under %q from %q", attempt.NetworkResult, attempt.Network, attempt.ClientIP, tt.want, tt.mode, tt.clientIP)
+			}
+			if counted := session.OffNetwork == 1; counted != tt.counted {
+				t.Errorf("got %d off network scans, want counted %t", session.OffNetwork, tt.counted)
+			}
+			if !tt.counted {
+				return
+			}
+
+			scan, ok := expectEvent(t, events, EventOffNetwork).(OffNetworkScan)
+			want := OffNetworkScan{SRN: testSRN, ClientIP: tt.clientIP, Accepted: tt.wantErr == nil, OffNetwork: 1}
+			if !ok || scan != want {
+				t.Errorf("got %+v, want %+v", scan, want)
+			}
+		})
+	}
+
+	// without a restriction nothing is checked
+	startTestSession(t, 0, false)
+	attempt, _ := validateAndRecord(t, 3, nil, "49.36.1.2", nil)
+	if attempt.Network != "" || attempt.NetworkResult != "" {
+		t.Errorf("a session without a restriction checked the network: %+v", attempt)
+	}
+}
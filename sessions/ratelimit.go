@@ -0,0 +1,227 @@
+package sessions
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+// EventScanLockout tells the teacher a student was locked out of scanning
+const EventScanLockout = "SCAN_LOCKOUT"
+
+// ScanLockout is the payload of an EventScanLockout event
+type ScanLockout struct {
+	SRN      string `json:"SRN"`
+	ClientIP string `json:"clientIP"`
+	Failures int    `json:"failures"`
+	Until    int64  `json:"until"` // unix ms
+}
+
+// Rate allows Attempts per period, in bursts of up to Attempts. Zero Attempts means no limit
+type Rate struct {
+	Attempts int
+	Per      time.Duration
+}
+
+// ParseRate reads a rate written as attempts/period, like "10/10s"
+func ParseRate(raw string) (Rate, error) {
+	rawAttempts, rawPer, found := strings.Cut(raw, "/")
+	if !found {
+		return Rate{}, fmt.Errorf("rate %q is not attempts/period", raw)
+	}
+	attempts, err := strconv.Atoi(rawAttempts)
+	if err != nil || attempts < 0 {
+		return Rate{}, fmt.Errorf("invalid attempts in rate %q", raw)
+	}
+	per, err := time.ParseDuration(rawPer)
+	if err != nil || per <= 0 {
+		return Rate{}, fmt.Errorf("invalid period in rate %q", raw)
+	}
+	return Rate{Attempts: attempts, Per: per}, nil
+}
+
+func (r Rate) String() string {
+	return fmt.Sprintf("%d/%s", r.Attempts, r.Per)
+}
+
+// RateLimits decides how fast scans are taken and when a student is locked out
+type RateLimits struct {
+	PerSRN     Rate
+	PerIP      Rate // a whole class may share one address behind the campus NAT
+	PerSession Rate
+
+	LockoutAfter    int           // failed scans before a lockout, 0 to never lock out
+	LockoutWindow   time.Duration // failures further apart than this start the count over
+	LockoutDuration time.Duration
+}
+
+// DefaultRateLimits keeps scripts from guessing or replaying QRs at full speed,
+// while leaving room for a full class scanning at once
+var DefaultRateLimits = RateLimits{
+	PerSRN:          Rate{Attempts: 10, Per: 10 * time.Second},
+	PerIP:           Rate{Attempts: 600, Per: 10 * time.Second},
+	PerSession:      Rate{Attempts: 600, Per: 10 * time.Second},
+	LockoutAfter:    10,
+	LockoutWindow:   time.Minute,
+	LockoutDuration: 5 * time.Minute,
+}
+
+// failures that look like guessing or replaying. Slow phones fail with these too,
+// which is why it takes several in a short window to be locked out
+var lockoutFailures = map[string]bool{
+	ErrTokenUnknown.Code:         true,
+	ErrTokenExpired.Code:         true,
+	ErrTokenBeforeSpotCheck.Code: true,
+	ErrSessionNotFound.Code:      true,
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+type failureCount struct {
+	count       int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+var (
+	rateLimits      = DefaultRateLimits
+	scanBuckets     = make(map[string]*bucket)       // "srn:", "ip:" or "session:" + key -> bucket
+	scanFailures    = make(map[string]*failureCount) // logged in SRN -> recent failures
+	lastBucketPurge time.Time
+	rateLimitMutex  sync.Mutex
+)
+
+// SetRateLimits replaces the scan rate limits and forgets every count made under the old ones
+func SetRateLimits(limits RateLimits) {
+	rateLimitMutex.Lock()
+	defer rateLimitMutex.Unlock()
+	rateLimits = limits
+	scanBuckets = make(map[string]*bucket)
+	scanFailures = make(map[string]*failureCount)
+}
+
+// AllowScan takes one attempt from the student's, the address' and the session's allowance.
+// SRN must be who the student logged in as, never one a request merely claims, or anyone
+// could lock a classmate out. It fails with ErrLockedOut while the student is locked out,
+// and ErrRateLimited when any allowance is used up, in which case none of them is charged
+func AllowScan(SRN string, clientIP string, sessionID models.ID) error {
+	now := Now()
+
+	rateLimitMutex.Lock()
+	defer rateLimitMutex.Unlock()
+
+	if failures, exists := scanFailures[SRN]; exists && now.Before(failures.lockedUntil) {
+		return ErrLockedOut
+	}
+
+	if now.Sub(lastBucketPurge) > time.Minute {
+		purgeFullBuckets(now)
+		lastBucketPurge = now
+	}
+
+	checks := []struct {
+		key  string
+		rate Rate
+	}{
+		{"srn:" + SRN, rateLimits.PerSRN},
+		{"ip:" + clientIP, rateLimits.PerIP},
+		{"session:" + sessionID.String(), rateLimits.PerSession},
+	}
+	var charged []*bucket
+	for _, check := range checks {
+		if check.rate.Attempts == 0 {
+			continue
+		}
+		b := refill(check.key, check.rate, now)
+		if b.tokens < 1 {
+			return ErrRateLimited
+		}
+		charged = append(charged, b)
+	}
+	for _, b := range charged {
+		b.tokens--
+	}
+	return nil
+}
+
+// refill tops a bucket up for the time since it was last used, creating it full
+func refill(key string, rate Rate, now time.Time) *bucket {
+	b, exists := scanBuckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(rate.Attempts), updated: now}
+		scanBuckets[key] = b
+		return b
+	}
+	elapsed := now.Sub(b.updated)
+	b.tokens = min(b.tokens+float64(rate.Attempts)*elapsed.Seconds()/rate.Per.Seconds(), float64(rate.Attempts))
+	b.updated = now
+	return b
+}
+
+// purgeFullBuckets forgets buckets that have had time to fill up again, and failure
+// counts that no longer matter. Called with rateLimitMutex held
+func purgeFullBuckets(now time.Time) {
+	longestPeriod := max(rateLimits.PerSRN.Per, rateLimits.PerIP.Per, rateLimits.PerSession.Per)
+	for key, b := range scanBuckets {
+		if now.Sub(b.updated) > longestPeriod {
+			delete(scanBuckets, key)
+		}
+	}
+	for SRN, failures := range scanFailures {
+		if now.After(failures.lockedUntil) && now.Sub(failures.lastFailure) > rateLimits.LockoutWindow {
+			delete(scanFailures, SRN)
+		}
+	}
+}
+
+// countScanOutcome tracks the student's recent failures. A success clears them, and one
+// failure too many locks the student out and tells the session's teacher
+func countScanOutcome(attempt models.ScanAttempt) {
+	if attempt.Outcome != models.ScanOutcomeRejected {
+		rateLimitMutex.Lock()
+		delete(scanFailures, attempt.SRN)
+		rateLimitMutex.Unlock()
+		return
+	}
+	if !lockoutFailures[attempt.FailureReason] {
+		return
+	}
+
+	now := Now()
+	rateLimitMutex.Lock()
+	if rateLimits.LockoutAfter == 0 {
+		rateLimitMutex.Unlock()
+		return
+	}
+	failures, exists := scanFailures[attempt.SRN]
+	if !exists || now.Sub(failures.lastFailure) > rateLimits.LockoutWindow {
+		failures = &failureCount{}
+		scanFailures[attempt.SRN] = failures
+	}
+	failures.count++
+	failures.lastFailure = now
+	if failures.count < rateLimits.LockoutAfter {
+		rateLimitMutex.Unlock()
+		return
+	}
+	failures.lockedUntil = now.Add(rateLimits.LockoutDuration)
+	lockout := ScanLockout{
+		SRN:      attempt.SRN,
+		ClientIP: attempt.ClientIP,
+		Failures: failures.count,
+		Until:    failures.lockedUntil.UnixMilli(),
+	}
+	failures.count = 0
+	rateLimitMutex.Unlock()
+
+	log.Printf("SRN %s locked out of scanning until %s after %d failures", attempt.SRN, time.UnixMilli(lockout.Until).Format(time.TimeOnly), lockout.Failures)
+	go notifySessionEvent(attempt.SessionID, EventScanLockout, lockout)
+}
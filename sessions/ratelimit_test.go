@@ -0,0 +1,115 @@
+package sessions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+// useRateLimits runs a test on a fake clock with the given limits
+func useRateLimits(t *testing.T, limits RateLimits) *fakeClock {
+	t.Helper()
+	clock := &fakeClock{now: t0}
+	SetClock(clock)
+	SetRateLimits(limits)
+	t.Cleanup(func() {
+		SetClock(nil)
+		SetRateLimits(DefaultRateLimits)
+	})
+	return clock
+}
+
+func TestAllowScan(t *testing.T) {
+	clock := useRateLimits(t, RateLimits{
+		PerSRN:     Rate{Attempts: 2, Per: time.Second},
+		PerIP:      Rate{Attempts: 3, Per: time.Second},
+		PerSession: Rate{Attempts: 100, Per: time.Second},
+	})
+
+	steps := []struct {
+		name    string
+		srn, ip string
+		advance time.Duration
+		wantErr error
+	}{
+		{name: "first scan", srn: "A", ip: "10.0.0.1"},
+		{name: "burst within the student's allowance", srn: "A", ip: "10.0.0.1"},
+		{name: "student out of attempts", srn: "A", ip: "10.0.0.1", wantErr: ErrRateLimited},
+		{name: "someone else on the same address", srn: "B", ip: "10.0.0.1"},
+		{name: "address out of attempts", srn: "C", ip: "10.0.0.1", wantErr: ErrRateLimited},
+		{name: "same student elsewhere isn't charged for the denied scan", srn: "C", ip: "10.0.0.2"},
+		{name: "half a period refills one attempt", srn: "A", ip: "10.0.0.3", advance: 500 * time.Millisecond},
+		{name: "but only one", srn: "A", ip: "10.0.0.3", wantErr: ErrRateLimited},
+	}
+
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		if err := AllowScan(step.srn, step.ip, testSessionID); err != step.wantErr {
+			t.Errorf("%s: got %v, want %v", step.name, err, step.wantErr)
+		}
+	}
+}
+
+func TestScanLockout(t *testing.T) {
+	clock := useRateLimits(t, RateLimits{
+		LockoutAfter:    3,
+		LockoutWindow:   time.Minute,
+		LockoutDuration: 5 * time.Minute,
+	})
+	events := RegisterForSessionEvents(testSessionID)
+	t.Cleanup(func() { UnregisterFromSessionEvents(testSessionID, events) })
+
+	fail := func(code string) {
+		countScanOutcome(models.ScanAttempt{SRN: testSRN, ClientIP: "10.0.0.1", SessionID: testSessionID, Outcome: models.ScanOutcomeRejected, FailureReason: code})
+	}
+
+	// a success starts the count over, and so does a failure after a quiet window
+	fail(ErrTokenUnknown.Code)
+	fail(ErrTokenExpired.Code)
+	countScanOutcome(models.ScanAttempt{SRN: testSRN, SessionID: testSessionID, Outcome: models.ScanOutcomeAccepted})
+	fail(ErrTokenUnknown.Code)
+	fail(ErrTokenUnknown.Code)
+	clock.Advance(2 * time.Minute)
+	fail(ErrTokenUnknown.Code)
+	fail(ErrAlreadyPresent.Code) // not suspicious
+	if err := AllowScan(testSRN, "10.0.0.1", testSessionID); err != nil {
+		t.Fatalf("locked out too early: %v", err)
+	}
+
+	fail(ErrTokenUnknown.Code)
+	fail(ErrTokenExpired.Code)
+	if err := AllowScan(testSRN, "10.0.0.1", testSessionID); err != ErrLockedOut {
+		t.Fatalf("got %v after 3 failures, want %v", err, ErrLockedOut)
+	}
+
+	select {
+	case event := <-events:
+		lockout, ok := event.Payload.(ScanLockout)
+		if event.Type != EventScanLockout || !ok {
+			t.Fatalf("got %s event with %T, want %s", event.Type, event.Payload, EventScanLockout)
+		}
+		want := ScanLockout{SRN: testSRN, ClientIP: "10.0.0.1", Failures: 3, Until: clock.now.Add(5 * time.Minute).UnixMilli()}
+		if lockout != want {
+			t.Errorf("got lockout %+v, want %+v", lockout, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the teacher was not told about the lockout")
+	}
+
+	clock.Advance(5 * time.Minute)
+	if err := AllowScan(testSRN, "10.0.0.1", testSessionID); err != nil {
+		t.Errorf("still locked out after the lockout ended: %v", err)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	if rate, err := ParseRate("10/10s"); err != nil || rate != (Rate{Attempts: 10, Per: 10 * time.Second}) {
+		t.Errorf("got %v, %v for 10/10s", rate, err)
+	}
+	for _, raw := range []string{"10", "ten/10s", "10/soon", "10/0s", "-1/1s"} {
+		if _, err := ParseRate(raw); err == nil {
+			t.Errorf("%q should not parse", raw)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package sessions
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/database"
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+// scan attempts waiting to be written. Scans never wait on the database;
+// if the writer falls this far behind, attempts are dropped and logged
+var scanAttemptQueue = make(chan models.ScanAttempt, 1024)
+
+// teacher latency probes waiting to be written, dropped the same way
+var teacherLatencyQueue = make(chan models.TeacherLatencyEstimate, 256)
+
+// listeners that want every scan attempt as it is recorded
+var (
+	scanAttemptListeners = make(map[chan models.ScanAttempt]struct{})
+	scanListenersMutex   sync.Mutex
+)
+
+// how many queued attempts are written in one insert at most
+const scanAttemptBatchSize = 200
+
+// RecordScanAttempt counts the attempt towards the session's failure rate and the
+// student's lockout, and queues it for the scan audit
+func RecordScanAttempt(attempt models.ScanAttempt) {
+	countScanOutcome(attempt)
+
+	SessionsMutex.Lock()
+	if session, exists := Sessions[attempt.SessionID]; exists {
+		session.ScanAttempts++
+		if attempt.Outcome == models.ScanOutcomeRejected {
+			session.ScanFailures++
+		}
+		if isLocationFailure(attempt) {
+			if attempt.LocationResult == models.LocationOutside {
+				session.LocationOutside++
+			} else {
+				session.LocationMissing++
+			}
+			go notifySessionEvent(attempt.SessionID, EventLocationFailure, LocationFailure{
+				SRN:      attempt.SRN,
+				Result:   attempt.LocationResult,
+				Distance: attempt.LocationDistance,
+				Accepted: attempt.Outcome != models.ScanOutcomeRejected,
+				Outside:  session.LocationOutside,
+				Missing:  session.LocationMissing,
+			})
+		}
+		if isOffNetwork(attempt) {
+			session.OffNetwork++
+			go notifySessionEvent(attempt.SessionID, EventOffNetwork, OffNetworkScan{
+				SRN:        attempt.SRN,
+				ClientIP:   attempt.ClientIP,
+				Accepted:   attempt.Outcome != models.ScanOutcomeRejected,
+				OffNetwork: session.OffNetwork,
+			})
+		}
+		Sessions[attempt.SessionID] = session
+	}
+	SessionsMutex.Unlock()
+
+	AuditScanAttempt(attempt)
+}
+
+// AuditScanAttempt queues the attempt for the scan audit without counting it towards
+// anything, for attempts turned away before their session was validated
+func AuditScanAttempt(attempt models.ScanAttempt) {
+	if attempt.CreatedAt.IsZero() {
+		attempt.CreatedAt = Now()
+	}
+	select {
+	case scanAttemptQueue <- attempt:
+	default:
+		log.Printf("Scan audit queue is full, dropping attempt by %s on session %d", attempt.SRN, attempt.SessionID)
+	}
+
+	scanListenersMutex.Lock()
+	defer scanListenersMutex.Unlock()
+	for ch := range scanAttemptListeners {
+		select {
+		case ch <- attempt:
+		default:
+			log.Printf("Scan attempt listener is full, dropping attempt by %s on session %d", attempt.SRN, attempt.SessionID)
+		}
+	}
+}
+
+// RegisterForScanAttempts returns a channel that receives every scan attempt of every
+// session once it is recorded
+func RegisterForScanAttempts() chan models.ScanAttempt {
+	scanListenersMutex.Lock()
+	defer scanListenersMutex.Unlock()
+	ch := make(chan models.ScanAttempt, 256)
+	scanAttemptListeners[ch] = struct{}{}
+	return ch
+}
+
+// UnregisterFromScanAttempts closes a channel from RegisterForScanAttempts
+func UnregisterFromScanAttempts(ch chan models.ScanAttempt) {
+	scanListenersMutex.Lock()
+	defer scanListenersMutex.Unlock()
+	if _, exists := scanAttemptListeners[ch]; exists {
+		close(ch)
+		delete(scanAttemptListeners, ch)
+	}
+}
+
+// queueTeacherLatency queues a teacher latency probe for the scan audit
+func queueTeacherLatency(estimate models.TeacherLatencyEstimate) {
+	select {
+	case teacherLatencyQueue <- estimate:
+	default:
+		log.Printf("Teacher latency queue is full, dropping probe %d of session %d", estimate.Probe, estimate.SessionID)
+	}
+}
+
+// StartScanAuditWriter writes queued scan attempts and teacher latency probes in batches
+// every flushInterval, and deletes records older than retention once an hour
+func StartScanAuditWriter(flushInterval time.Duration, retention time.Duration) {
+	go func() {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		batch := make([]models.ScanAttempt, 0, scanAttemptBatchSize)
+		var estimates []models.TeacherLatencyEstimate
+		for {
+			select {
+			case attempt := <-scanAttemptQueue:
+				batch = append(batch, attempt)
+				if len(batch) < scanAttemptBatchSize {
+					continue
+				}
+			case estimate := <-teacherLatencyQueue:
+				estimates = append(estimates, estimate)
+				continue
+			case <-ticker.C:
+			}
+			if err := database.CreateScanAttempts(batch); err != nil {
+				log.Printf("Failed to write %d scan attempts: %v", len(batch), err)
+			}
+			updateTimingProfiles(batch)
+			batch = batch[:0]
+			if err := database.CreateTeacherLatencyEstimates(estimates); err != nil {
+				log.Printf("Failed to write %d teacher latency probes: %v", len(estimates), err)
+			}
+			estimates = estimates[:0]
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			pruned, err := database.PruneScanAttempts(Now().Add(-retention))
+			if err != nil {
+				log.Printf("Failed to prune scan attempts: %v", err)
+			} else if pruned > 0 {
+				log.Printf("Pruned %d scan attempts older than %s", pruned, retention)
+			}
+			pruned, err = database.PruneTeacherLatencyEstimates(Now().Add(-retention))
+			if err != nil {
+				log.Printf("Failed to prune teacher latency probes: %v", err)
+			} else if pruned > 0 {
+				log.Printf("Pruned %d teacher latency probes older than %s", pruned, retention)
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,126 @@
+package sessions
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+const (
+	DefaultSpotCheckDuration = 30 * time.Second
+	MaxSpotCheckDuration     = 5 * time.Minute
+)
+
+// StartSpotCheck opens a re-verification round for the session. Every student
+// currently marked present has to scan again within the given duration
+func StartSpotCheck(sessionID models.ID, duration time.Duration) (models.SpotCheck, error) {
+	if duration <= 0 {
+		duration = DefaultSpotCheckDuration
+	}
+	if duration > MaxSpotCheckDuration {
+		duration = MaxSpotCheckDuration
+	}
+
+	SessionsMutex.Lock()
+	defer SessionsMutex.Unlock()
+
+	session, exists := Sessions[sessionID]
+	if !exists {
+		return models.SpotCheck{}, fmt.Errorf("session %d not found", sessionID)
+	}
+
+	if activeSpotCheck(session) != nil {
+		return models.SpotCheck{}, errors.New("a spot-check is already running")
+	}
+
+	var pending []string
+	for _, student := range session.Students {
+		if student.IsPresent {
+			pending = append(pending, student.SRN)
+		}
+	}
+
+	now := Now().UnixMilli()
+	spotCheck := models.SpotCheck{
+		ID:        len(session.SpotChecks) + 1,
+		StartedAt: now,
+		EndsAt:    now + duration.Milliseconds(),
+		Pending:   pending,
+	}
+	session.SpotChecks = append(session.SpotChecks, spotCheck)
+	Sessions[sessionID] = session
+
+	log.Printf("Spot-check %d started in session %d for %d students", spotCheck.ID, sessionID, len(pending))
+
+	time.AfterFunc(duration, func() { finishSpotCheck(sessionID, spotCheck.ID) })
+	go notifySessionEvent(sessionID, EventSpotCheckStarted, cloneSpotCheck(spotCheck))
+
+	return cloneSpotCheck(spotCheck), nil
+}
+
+// verifySpotCheck records a valid re-scan by a student the running spot-check is waiting on,
+// and returns the spot-check's ID. Callers must hold SessionsMutex
+func verifySpotCheck(sessionID models.ID, session models.Session, srn string) int {
+	spotCheck := activeSpotCheck(session)
+	spotCheck.Pending = slices.DeleteFunc(slices.Clone(spotCheck.Pending), func(s string) bool { return s == srn })
+	spotCheck.Verified = append(slices.Clone(spotCheck.Verified), srn)
+	Sessions[sessionID] = session
+
+	go notifySessionEvent(sessionID, EventSpotCheckUpdate, cloneSpotCheck(*spotCheck))
+	return spotCheck.ID
+}
+
+// finishSpotCheck flags everyone who did not re-scan in time and pushes the result
+func finishSpotCheck(sessionID models.ID, spotCheckID int) {
+	SessionsMutex.Lock()
+	defer SessionsMutex.Unlock()
+
+	session, exists := Sessions[sessionID]
+	if !exists {
+		// session ended before the spot-check did
+		return
+	}
+
+	spotCheck := activeSpotCheck(session)
+	if spotCheck == nil || spotCheck.ID != spotCheckID {
+		return
+	}
+
+	spotCheck.Flagged = spotCheck.Pending
+	spotCheck.Pending = nil
+	spotCheck.Completed = true
+	Sessions[sessionID] = session
+
+	log.Printf("Spot-check %d in session %d finished: %d verified, %d flagged", spotCheck.ID, sessionID, len(spotCheck.Verified), len(spotCheck.Flagged))
+	go notifySessionEvent(sessionID, EventSpotCheckResult, cloneSpotCheck(*spotCheck))
+}
+
+// activeSpotCheck returns the running spot-check of the session, if any.
+// The returned pointer aliases session.SpotChecks, so callers must hold SessionsMutex
+func activeSpotCheck(session models.Session) *models.SpotCheck {
+	if len(session.SpotChecks) == 0 {
+		return nil
+	}
+	last := &session.SpotChecks[len(session.SpotChecks)-1]
+	if last.Completed {
+		return nil
+	}
+	return last
+}
+
+func isAwaitingSpotCheck(session models.Session, srn string) bool {
+	spotCheck := activeSpotCheck(session)
+	return spotCheck != nil && slices.Contains(spotCheck.Pending, srn)
+}
+
+// cloneSpotCheck copies the SRN lists so the event payload doesn't share memory with the session
+func cloneSpotCheck(spotCheck models.SpotCheck) models.SpotCheck {
+	spotCheck.Pending = slices.Clone(spotCheck.Pending)
+	spotCheck.Verified = slices.Clone(spotCheck.Verified)
+	spotCheck.Flagged = slices.Clone(spotCheck.Flagged)
+	return spotCheck
+}
@@ -1,72 +1,139 @@
 package sessions
 
 import (
-	"errors"
-	"fmt"
-	"log"
 	"slices"
 	"strconv"
 
 	"github.com/anuragrao04/qr-attendance-backend/models"
 )
 
-func ValidateScan(scan models.ScanMessage, clockDrift int64, studentLatency int64) (bool, error) {
-	// Fetch the session
+// how long after its QR expired a scan is still accepted, in ms, before any
+// slack from the student's timing profile
+const scanTolerance = 100
+
+// ValidateScan checks a scan, sent from clientIP, against the session's current and past random IDs.
+// The returned attempt records the timing that went into the decision, for the scan audit. A valid
+// scan from a student the running spot-check is waiting on re-verifies them there and then, and
+// sets the attempt's SpotCheckID; other valid scans still have to mark the student present
+func ValidateScan(scan models.ScanMessage, timing ScanContext, clientIP string) (models.ScanAttempt, error) {
+	int64ScannedAt, _ := strconv.ParseInt(scan.ScannedAt, 10, 64)
+	attempt := models.ScanAttempt{
+		SRN:             scan.SRN,
+		SessionID:       scan.SessionID,
+		ScannedRandomID: scan.ScannedRandomID,
+		RawScannedAt:    int64ScannedAt,
+		ClockDrift:      timing.ClockDrift,
+		StudentLatency:  min(max(timing.StudentLatency, 0), MaxRoundTrip/2),
+		RoundTrip:       min(max(timing.RoundTrip, 0), MaxRoundTrip),
+		ClockSyncRounds: timing.Samples,
+		ExtraTolerance:  min(max(timing.ExtraTolerance, 0), MaxExtraTolerance),
+		ClientIP:        clientIP,
+		Outcome:         models.ScanOutcomeAccepted,
+	}
+
+	err := validateScan(scan, &attempt)
+	if err != nil {
+		attempt.Outcome = models.ScanOutcomeRejected
+		attempt.FailureReason = AsScanError(err).Code
+	}
+	return attempt, err
+}
+
+func validateScan(scan models.ScanMessage, attempt *models.ScanAttempt) error {
+	// Fetch the session, holding it until a spot-check re-scan is settled
 	SessionsMutex.Lock()
+	defer SessionsMutex.Unlock()
 	session, exists := Sessions[scan.SessionID]
-	SessionsMutex.Unlock()
 
 	if !exists {
-		return false, errors.New("Invalid session ID")
+		return ErrSessionNotFound
 	}
 
-	// Check if the student is already marked present
+	// Check if the student is already marked present.
+	// Students re-verifying for a running spot-check are allowed through
+	awaitingSpotCheck := isAwaitingSpotCheck(session, scan.SRN)
 	for _, student := range session.Students {
-		if student.SRN == scan.SRN && student.IsPresent {
-			return false, errors.New("Student already marked present")
+		if student.SRN == scan.SRN && student.IsPresent && !awaitingSpotCheck {
+			return ErrAlreadyPresent
 		}
 	}
 
+	locateScan(session.Geofence, scan.Location, attempt)
+	checkNetwork(session.Network, attempt)
+	if err := validateToken(session, scan, attempt); err != nil {
+		return err
+	}
+	if err := enforceNetwork(*attempt); err != nil {
+		return err
+	}
+	if err := enforceGeofence(*attempt); err != nil {
+		return err
+	}
+
+	if awaitingSpotCheck {
+		attempt.SpotCheckID = verifySpotCheck(scan.SessionID, session, scan.SRN)
+	}
+	return nil
+}
+
+// validateToken checks the scanned ID was on screen when the student scanned it
+func validateToken(session models.Session, scan models.ScanMessage, attempt *models.ScanAttempt) error {
 	// Adjust ScannedAt for both clock drift and teacher clock drift
-	int64ScannedAt, _ := strconv.ParseInt(scan.ScannedAt, 10, 64)
-	adjustedScannedAt := int64ScannedAt + clockDrift - studentLatency - session.TeacherQRRenderingLatency
+	adjustedScannedAt := attempt.RawScannedAt + attempt.ClockDrift - attempt.StudentLatency - session.TeacherQRRenderingLatency
+	attempt.AdjustedScannedAt = adjustedScannedAt
+	attempt.TeacherLatency = session.TeacherQRRenderingLatency
 
 	// Validate against current RandomID
 	if session.CurrentRandomID.ID == scan.ScannedRandomID {
-		log.Println("CurrentID Delta: ", adjustedScannedAt-session.CurrentRandomID.ExpiredAt)
-		if adjustedScannedAt-session.CurrentRandomID.ExpiredAt <= 100 {
-			return true, nil
+		attempt.TokenCreatedAt = session.CurrentRandomID.CreatedAt
+		attempt.ExpiryDelta = adjustedScannedAt - session.CurrentRandomID.ExpiredAt
+		if attempt.ExpiryDelta <= scanTolerance+attempt.ExtraTolerance {
+			return validateSpotCheckToken(session, scan.SRN, session.CurrentRandomID)
 		}
-		return false, errors.New("Current RandomID is invalid or expired")
+		return ErrTokenExpired
 	}
 
 	// Validate against past RandomIDs
 	for _, pastID := range slices.Backward(session.PastRandomIDs) {
-		log.Println("PastID Delta: ", adjustedScannedAt-pastID.ExpiredAt)
 		if pastID.ID == scan.ScannedRandomID {
-			if adjustedScannedAt-pastID.ExpiredAt <= 100 {
-				return true, nil
+			attempt.TokenCreatedAt = pastID.CreatedAt
+			attempt.ExpiryDelta = adjustedScannedAt - pastID.ExpiredAt
+			if attempt.ExpiryDelta <= scanTolerance+attempt.ExtraTolerance {
+				return validateSpotCheckToken(session, scan.SRN, pastID)
 			}
-			return false, errors.New("Past RandomID is invalid or expired")
+			return ErrTokenExpired
 		}
 	}
 
-	return false, errors.New("Scanned RandomID is not valid for this session")
+	return ErrTokenUnknown
 }
 
-func MarkStudentPresent(sessionID uint32, srn string) error {
+// a re-scan for a spot-check only counts if the QR was shown after the spot-check started
+func validateSpotCheckToken(session models.Session, srn string, randomID models.RandomID) error {
+	if !isAwaitingSpotCheck(session, srn) {
+		return nil
+	}
+	if randomID.CreatedAt < activeSpotCheck(session).StartedAt {
+		return ErrTokenBeforeSpotCheck
+	}
+	return nil
+}
+
+func MarkStudentPresent(sessionID models.ID, srn string) error {
 	SessionsMutex.Lock()
 	defer SessionsMutex.Unlock()
 
 	session, exists := Sessions[sessionID]
 	if !exists {
-		return fmt.Errorf("session %d not found", sessionID)
+		return ErrSessionNotFound
 	}
 
 	// Update the student's presence
 	updated := false
+	found := false
 	for i, student := range session.Students {
 		if student.SRN == srn {
+			found = true
 			// Only update if status actually changes
 			if !session.Students[i].IsPresent {
 				session.Students[i].IsPresent = true
@@ -76,14 +143,18 @@ func MarkStudentPresent(sessionID uint32, srn string) error {
 		}
 	}
 
+	if !found {
+		return ErrNotInRoster
+	}
+
 	if updated {
 		// Save back the updated session
 		Sessions[sessionID] = session
-		
+
 		// Notify about the change in a separate goroutine to avoid blocking
 		go notifyAttendanceChange(sessionID)
 	}
-	
+
 	return nil
 }
 
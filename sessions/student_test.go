@@ -0,0 +1,309 @@
+package sessions
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// sequentialTokens hands out 1, 2, 3...
+type sequentialTokens struct {
+	next uint64
+}
+
+func (s *sequentialTokens) Uint64() uint64 {
+	s.next++
+	return s.next
+}
+
+// scriptedTokens hands out the given values in order
+type scriptedTokens []uint64
+
+func (s *scriptedTokens) Uint64() uint64 {
+	next := (*s)[0]
+	*s = (*s)[1:]
+	return next
+}
+
+const (
+	testSessionID = 4242
+	testSRN       = "PES1UG21CS001"
+)
+
+// t0 is when the first QR of the test session is shown
+var t0 = time.UnixMilli(1_700_000_000_000)
+
+// startTestSession runs a session on a fake clock for three rotations. Afterwards
+// QRs 1 and 2 are archived, expiring at t0+200 and t0+400, and QR 3 is current,
+// expiring at t0+600
+func startTestSession(t *testing.T, teacherLatency int64, present bool) {
+	t.Helper()
+
+	clock := &fakeClock{now: t0}
+	SetClock(clock)
+	SetTokenSource(&sequentialTokens{})
+
+	SessionsMutex.Lock()
+	Sessions[testSessionID] = models.Session{
+		Students:                  []models.StudentInASession{{SRN: testSRN, IsPresent: present}},
+		TeacherQRRenderingLatency: teacherLatency,
+	}
+	SessionsMutex.Unlock()
+
+	t.Cleanup(func() {
+		SessionsMutex.Lock()
+		delete(Sessions, testSessionID)
+		SessionsMutex.Unlock()
+		SetClock(nil)
+		SetTokenSource(nil)
+	})
+
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			clock.Advance(RandomIDLifetime)
+		}
+		if _, err := RotateRandomID(testSessionID); err != nil {
+			t.Fatalf("failed to rotate QR: %v", err)
+		}
+	}
+}
+
+// startRestrictedTestSession starts the test session with restrict applied to it, and
+// returns a channel of its events
+func startRestrictedTestSession(t *testing.T, restrict func(*models.Session)) chan SessionEvent {
+	t.Helper()
+	startTestSession(t, 0, false)
+	events := RegisterForSessionEvents(testSessionID)
+	t.Cleanup(func() { UnregisterFromSessionEvents(testSessionID, events) })
+
+	SessionsMutex.Lock()
+	session := Sessions[testSessionID]
+	restrict(&session)
+	Sessions[testSessionID] = session
+	SessionsMutex.Unlock()
+	return events
+}
+
+// validateAndRecord scans QR randomID of the test session from clientIP, fails unless the
+// scan gets wantErr, and records it. It returns the attempt and the session afterwards
+func validateAndRecord(t *testing.T, randomID models.ID, location *models.DeviceLocation, clientIP string, wantErr error) (models.ScanAttempt, models.Session) {
+	t.Helper()
+	attempt, err := ValidateScan(models.ScanMessage{
+		SessionID:       testSessionID,
+		ScannedRandomID: randomID,
+		ScannedAt:       strconv.FormatInt(t0.UnixMilli()+400, 10),
+		SRN:             testSRN,
+		Location:        location,
+	}, ScanContext{}, clientIP)
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("got error %v, want %v", err, wantErr)
+	}
+
+	RecordScanAttempt(attempt)
+	SessionsMutex.Lock()
+	defer SessionsMutex.Unlock()
+	return attempt, Sessions[testSessionID]
+}
+
+// expectEvent waits for the next session event and fails unless it is of eventType
+func expectEvent(t *testing.T, events chan SessionEvent, eventType string) interface{} {
+	t.Helper()
+	select {
+	case event := <-events:
+		if event.Type != eventType {
+			t.Fatalf("got %s event, want %s", event.Type, eventType)
+		}
+		return event.Payload
+	case <-time.After(time.Second):
+		t.Fatalf("no %s event", eventType)
+		return nil
+	}
+}
+
+func TestValidateScan(t *testing.T) {
+	tests := []struct {
+		name           string
+		sessionID      models.ID
+		randomID       models.ID
+		scannedAt      int64 // ms after t0, on the student's clock
+		clockDrift     int64
+		studentLatency int64
+		teacherLatency int64
+		extraTolerance int64
+		present        bool
+		wantErr        error
+		wantDelta      int64
+	}{
+		{name: "current ID as it appears", randomID: 3, scannedAt: 400, wantDelta: -200},
+		{name: "current ID at the tolerance boundary", randomID: 3, scannedAt: 700, wantDelta: 100},
+		{name: "current ID just past the boundary", randomID: 3, scannedAt: 701, wantErr: ErrTokenExpired, wantDelta: 101},
+		{name: "past ID at the tolerance boundary", randomID: 2, scannedAt: 500, wantDelta: 100},
+		{name: "past ID just past the boundary", randomID: 2, scannedAt: 501, wantErr: ErrTokenExpired, wantDelta: 101},
+		{name: "oldest past ID while it was shown", randomID: 1, scannedAt: 100, wantDelta: -100},
+		{name: "oldest past ID long after", randomID: 1, scannedAt: 600, wantErr: ErrTokenExpired, wantDelta: 400},
+		{name: "phone clock behind, corrected by drift", randomID: 3, scannedAt: 400 - 5000, clockDrift: 5000, wantDelta: -200},
+		{name: "phone clock ahead, corrected by drift", randomID: 2, scannedAt: 300 + 5000, clockDrift: -5000, wantDelta: -100},
+		{name: "phone clock ahead, uncorrected", randomID: 3, scannedAt: 400 + 5000, wantErr: ErrTokenExpired, wantDelta: 4800},
+		{name: "student latency pulls a late scan back", randomID: 3, scannedAt: 760, studentLatency: 60, wantDelta: 100},
+		{name: "student latency not enough", randomID: 3, scannedAt: 760, studentLatency: 59, wantErr: ErrTokenExpired, wantDelta: 101},
+		{name: "student latency is capped", randomID: 3, scannedAt: 5000, studentLatency: 60_000, wantErr: ErrTokenExpired, wantDelta: 5000 - MaxRoundTrip/2 - 600},
+		{name: "teacher render latency pulls a late scan back", randomID: 3, scannedAt: 780, teacherLatency: 80, wantDelta: 100},
+		{name: "drift and both latencies combined", randomID: 2, scannedAt: 500 - 1000 + 30 + 50, clockDrift: 1000, studentLatency: 30, teacherLatency: 50, wantDelta: 100},
+		{name: "profile slack lets a slow phone through", randomID: 3, scannedAt: 750, extraTolerance: 50, wantDelta: 150},
+		{name: "profile slack is capped", randomID: 3, scannedAt: 851, extraTolerance: 500, wantErr: ErrTokenExpired, wantDelta: 251},
+		{name: "unknown ID", randomID: 99, scannedAt: 400, wantErr: ErrTokenUnknown},
+		{name: "unknown session", sessionID: 1, randomID: 3, scannedAt: 400, wantErr: ErrSessionNotFound},
+		{name: "already present", randomID: 3, scannedAt: 400, present: true, wantErr: ErrAlreadyPresent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			startTestSession(t, tt.teacherLatency, tt.present)
+
+			sessionID := tt.sessionID
+			if sessionID == 0 {
+				sessionID = testSessionID
+			}
+			scan := models.ScanMessage{
+				SessionID:       sessionID,
+				ScannedRandomID: tt.randomID,
+				ScannedAt:       strconv.FormatInt(t0.UnixMilli()+tt.scannedAt, 10),
+				SRN:             testSRN,
+			}
+
+			attempt, err := ValidateScan(scan, ScanContext{ClockDrift: tt.clockDrift, StudentLatency: tt.studentLatency, ExtraTolerance: tt.extraTolerance}, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if attempt.ExpiryDelta != tt.wantDelta {
+				t.Errorf("got expiry delta %d, want %d", attempt.ExpiryDelta, tt.wantDelta)
+			}
+
+			wantOutcome := models.ScanOutcomeAccepted
+			if tt.wantErr != nil {
+				wantOutcome = models.ScanOutcomeRejected
+				if code := tt.wantErr.(*ScanError).Code; attempt.FailureReason != code {
+					t.Errorf("got failure reason %q, want %q", attempt.FailureReason, code)
+				}
+			}
+			if attempt.Outcome != wantOutcome {
+				t.Errorf("got outcome %s, want %s", attempt.Outcome, wantOutcome)
+			}
+		})
+	}
+}
+
+func TestValidateScanSettlesSpotChecks(t *testing.T) {
+	scan := func(randomID models.ID) (models.ScanAttempt, error) {
+		return ValidateScan(models.ScanMessage{
+			SessionID:       testSessionID,
+			ScannedRandomID: randomID,
+			ScannedAt:       strconv.FormatInt(t0.UnixMilli()+400, 10),
+			SRN:             testSRN,
+		}, ScanContext{}, "")
+	}
+
+	startTestSession(t, 0, true)
+	if _, err := StartSpotCheck(testSessionID, time.Minute); err != nil {
+		t.Fatalf("failed to start spot-check: %v", err)
+	}
+	for _, step := range []struct {
+		name      string
+		randomID  models.ID
+		wantErr   error
+		spotCheck int
+	}{
+		{name: "QR from before the spot-check", randomID: 2, wantErr: ErrTokenBeforeSpotCheck},
+		{name: "re-scan", randomID: 3, spotCheck: 1},
+		{name: "re-verified already", randomID: 3, wantErr: ErrAlreadyPresent},
+	} {
+		attempt, err := scan(step.randomID)
+		if !errors.Is(err, step.wantErr) || attempt.SpotCheckID != step.spotCheck {
+			t.Errorf("%s: got %v for spot-check %d, want %v for %d", step.name, err, attempt.SpotCheckID, step.wantErr, step.spotCheck)
+		}
+	}
+	SessionsMutex.Lock()
+	spotCheck := Sessions[testSessionID].SpotChecks[0]
+	SessionsMutex.Unlock()
+	if len(spotCheck.Pending) != 0 || !slices.Equal(spotCheck.Verified, []string{testSRN}) {
+		t.Errorf("got %+v, want the student verified", spotCheck)
+	}
+
+	// a spot-check that ends first leaves a present student nothing to scan for
+	startTestSession(t, 0, true)
+	if _, err := StartSpotCheck(testSessionID, time.Minute); err != nil {
+		t.Fatalf("failed to start spot-check: %v", err)
+	}
+	finishSpotCheck(testSessionID, 1)
+	if attempt, err := scan(3); !errors.Is(err, ErrAlreadyPresent) || attempt.SpotCheckID != 0 {
+		t.Errorf("got %v for spot-check %d after it ended, want %v", err, attempt.SpotCheckID, ErrAlreadyPresent)
+	}
+}
+
+func TestRotateRandomIDArchivesOnTheClock(t *testing.T) {
+	startTestSession(t, 0, false)
+
+	SessionsMutex.Lock()
+	session := Sessions[testSessionID]
+	SessionsMutex.Unlock()
+
+	want := []models.RandomID{
+		{ID: 1, CreatedAt: t0.UnixMilli(), ExpiredAt: t0.UnixMilli() + 200},
+		{ID: 2, CreatedAt: t0.UnixMilli() + 200, ExpiredAt: t0.UnixMilli() + 400},
+	}
+	if len(session.PastRandomIDs) != len(want) {
+		t.Fatalf("got %d past IDs, want %d", len(session.PastRandomIDs), len(want))
+	}
+	for i := range want {
+		if session.PastRandomIDs[i] != want[i] {
+			t.Errorf("past ID %d: got %+v, want %+v", i, session.PastRandomIDs[i], want[i])
+		}
+	}
+	if current := session.CurrentRandomID; current.ID != 3 || current.ExpiredAt != t0.UnixMilli()+600 {
+		t.Errorf("got current ID %+v, want ID 3 expiring at t0+600", current)
+	}
+}
+
+func TestRotateRandomIDNeverRepeats(t *testing.T) {
+	startTestSession(t, 0, false)
+
+	// 3 is current and 1 is archived, so only 4 is free
+	tokens := scriptedTokens{3, 1, 0, 4}
+	SetTokenSource(&tokens)
+
+	randomID, err := RotateRandomID(testSessionID)
+	if err != nil {
+		t.Fatalf("failed to rotate QR: %v", err)
+	}
+	if randomID.ID != 4 {
+		t.Errorf("got QR ID %d, want 4", randomID.ID)
+	}
+}
+
+func TestAllocateIDWidth(t *testing.T) {
+	t.Cleanup(func() { SetTokenSource(nil) })
+
+	// all 64 bits set, masked down to the width asked for
+	tokens := scriptedTokens{^uint64(0), ^uint64(0)}
+	SetTokenSource(&tokens)
+
+	never := func(models.ID) bool { return false }
+	if id := allocateID(LegacyIDBits, never); id != 1<<32-1 {
+		t.Errorf("got legacy ID %d, want %d", id, uint64(1<<32-1))
+	}
+	if id := allocateID(models.IDBits, never); id != models.MaxID {
+		t.Errorf("got ID %d, want %d", id, uint64(models.MaxID))
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,47 +14,106 @@ import (
 	"github.com/anuragrao04/qr-attendance-backend/models"
 )
 
-var Sessions = make(map[uint32]models.Session) // SessionID -> Session
+var Sessions = make(map[models.ID]models.Session) // SessionID -> Session
 var SessionsMutex sync.Mutex
 
-// generates a new session of the given classroom, populating the student details on the way
-func CreateSession(classroomTableName string, teacherQRRenderingLatency int64) (uint32, []models.StudentInASession, error) {
-	students, err := database.GetStudentsInAClassroom(classroomTableName)
+// generates a new session of the given classrooms, populating the student details on the way.
+// Combined sessions get the union of the rosters, deduplicated by SRN
+func CreateSession(config models.SessionConfig, teacherQRRenderingLatency int64) (models.ID, []models.StudentInASession, error) {
+	classroomTableNames := config.ClassroomTables
+	if len(classroomTableNames) == 0 {
+		return 0, nil, errors.New("at least one classroom is required")
+	}
+
+	var students []models.StudentInASession
+	seen := make(map[string]string) // SRN -> section it was first seen in
+	for _, classroomTableName := range classroomTableNames {
+		classroomStudents, err := database.GetStudentsInAClassroom(classroomTableName)
+		if err != nil {
+			log.Println("Failed to get students in classroom:", err)
+			return 0, nil, err
+		}
+		for _, student := range classroomStudents {
+			if section, exists := seen[student.SRN]; exists {
+				log.Printf("SRN %s is in both %s and %s, keeping %s", student.SRN, section, classroomTableName, section)
+				continue
+			}
+			seen[student.SRN] = classroomTableName
+			students = append(students, student)
+		}
+	}
+
+	geofence, err := resolveGeofence(config)
 	if err != nil {
-		log.Println("Failed to get students in classroom:", err)
+		log.Println("Failed to resolve the session's geofence:", err)
+		return 0, nil, err
+	}
+	network, err := resolveNetworkRestriction(config)
+	if err != nil {
+		log.Println("Failed to resolve the session's network restriction:", err)
 		return 0, nil, err
 	}
 
 	log.Println("Teacher Rendering Latency: ", teacherQRRenderingLatency)
 
-	// create a unique sessionID
-	sessID := uint32(rand.Uint32())
+	// create a unique sessionID, reserving it before the lock is released
+	startedAt := Now()
 	SessionsMutex.Lock()
-	defer SessionsMutex.Unlock()
+	sessID := allocateID(config.IDBits, func(id models.ID) bool {
+		_, taken := Sessions[id]
+		return taken
+	})
 	Sessions[sessID] = models.Session{
-		ClassroomTable:            classroomTableName,
+		Owner:                     config.Owner,
+		CourseOfferingID:          config.CourseOfferingID,
+		StartedAt:                 startedAt.UnixMilli(),
+		IDBits:                    config.IDBits,
+		ClassroomTables:           classroomTableNames,
 		Students:                  students,
 		TeacherQRRenderingLatency: teacherQRRenderingLatency,
+		Geofence:                  geofence,
+		Network:                   network,
+	}
+	SessionsMutex.Unlock()
+
+	if slotID := matchTimetableSlot(sessID, config.Owner, classroomTableNames, startedAt); slotID != 0 {
+		SessionsMutex.Lock()
+		if session, exists := Sessions[sessID]; exists {
+			session.TimetableSlotID = slotID
+			Sessions[sessID] = session
+		}
+		SessionsMutex.Unlock()
 	}
+
 	log.Println("Created new session with ID:", sessID)
 	go notifyAttendanceChange(sessID) // push the first attendance list
 	return sessID, students, nil
 }
 
-// updates the current random ID for a session and archives the previous one
-func UpdateRandomID(sessionID uint32, newRandomID models.RandomID) error {
+// RotateRandomID generates the session's next QR, valid for RandomIDLifetime, and
+// archives the previous one. A new ID never repeats one the session has shown
+func RotateRandomID(sessionID models.ID) (models.RandomID, error) {
 	SessionsMutex.Lock()
 	defer SessionsMutex.Unlock()
 
 	// Retrieve the session by ID
 	session, exists := Sessions[sessionID]
 	if !exists {
-		return errors.New("session not found")
+		return models.RandomID{}, errors.New("session not found")
+	}
+
+	now := Now().UnixMilli()
+	newRandomID := models.RandomID{
+		ID: allocateID(session.IDBits, func(id models.ID) bool {
+			return id == session.CurrentRandomID.ID || slices.ContainsFunc(session.PastRandomIDs, func(past models.RandomID) bool { return past.ID == id })
+		}),
+		CreatedAt: now,
+		ExpiredAt: now + RandomIDLifetime.Milliseconds(),
 	}
 
 	// Mark the current random ID as expired and archive it
 	if session.CurrentRandomID.ID != 0 {
-		session.CurrentRandomID.ExpiredAt = time.Now().UnixMilli() // Set expiration timestamp
+		session.CurrentRandomID.ExpiredAt = now // Set expiration timestamp
 		session.PastRandomIDs = append(session.PastRandomIDs, session.CurrentRandomID)
 	}
 
@@ -61,17 +122,81 @@ func UpdateRandomID(sessionID uint32, newRandomID models.RandomID) error {
 
 	// Save the updated session back to the map
 	Sessions[sessionID] = session
+	return newRandomID, nil
+}
+
+// EndSession removes a live session and persists every student's final status. Only the
+// call that removes the session persists it, so the teacher's socket closing while an admin
+// force-ends the session can't write two records
+func EndSession(sessionID models.ID) error {
+	session, removed := removeSession(sessionID)
+	if !removed {
+		return fmt.Errorf("session %d: %w", sessionID, ErrSessionNotFound)
+	}
+
+	record := models.SessionRecord{
+		SessionID:        sessionID,
+		CourseOfferingID: session.CourseOfferingID,
+		Owner:            session.Owner,
+		ClassroomTables:  strings.Join(session.ClassroomTables, ","),
+		TimetableSlotID:  session.TimetableSlotID,
+		StartedAt:        time.UnixMilli(session.StartedAt),
+		EndedAt:          Now(),
+	}
+	for _, student := range session.Students {
+		record.Records = append(record.Records, models.AttendanceRecord{
+			CourseOfferingID: session.CourseOfferingID,
+			SRN:              student.SRN,
+			PRN:              student.PRN,
+			Name:             student.Name,
+			Section:          student.Section,
+			IsPresent:        student.IsPresent,
+			IsGuest:          student.IsGuest,
+		})
+	}
+
+	for _, spotCheck := range session.SpotChecks {
+		record.SpotChecks = append(record.SpotChecks, models.SpotCheckRecord{
+			SpotCheckID: spotCheck.ID,
+			StartedAt:   time.UnixMilli(spotCheck.StartedAt),
+			EndsAt:      time.UnixMilli(spotCheck.EndsAt),
+			Pending:     spotCheck.Pending,
+			Verified:    spotCheck.Verified,
+			Flagged:     spotCheck.Flagged,
+			Completed:   spotCheck.Completed,
+		})
+	}
+
+	err := database.SaveSessionRecord(&record)
+	if err != nil {
+		log.Printf("Failed to persist session %d: %v", sessionID, err)
+		return err
+	}
+	log.Printf("Session %d persisted as record %d", sessionID, record.ID)
 	return nil
 }
 
-func DeleteSession(sessionID uint32) {
+// DeleteSession drops a live session and closes every listener's channels
+func DeleteSession(sessionID models.ID) {
+	removeSession(sessionID)
+}
+
+// removeSession is DeleteSession, returning the session it dropped. The session is read and
+// deleted under one lock, so of several racing callers only one gets it
+func removeSession(sessionID models.ID) (models.Session, bool) {
 	SessionsMutex.Lock()
-	defer SessionsMutex.Unlock()
+	session, exists := Sessions[sessionID]
 	delete(Sessions, sessionID)
+	releasePendingDecisions(sessionID)
+	SessionsMutex.Unlock()
+
+	closeAttendanceListeners(sessionID)
+	closeSessionEventListeners(sessionID)
+	return session, exists
 }
 
 // returns absentee list and presentee list
-func GetAttendanceList(sessionID uint32) ([]models.StudentInASession, []models.StudentInASession, error) {
+func GetAttendanceList(sessionID models.ID) ([]models.StudentInASession, []models.StudentInASession, error) {
 	SessionsMutex.Lock()
 	defer SessionsMutex.Unlock()
 
@@ -93,84 +218,103 @@ func GetAttendanceList(sessionID uint32) ([]models.StudentInASession, []models.S
 	return absentees, presentees, nil
 }
 
-func ToggleStudentAttendance(sessionID uint32, srn string) error {
+// flips a student's presence by hand and records the change in the audit log
+func ToggleStudentAttendance(sessionID models.ID, srn string, override models.ManualOverride) error {
+	if err := override.Validate(); err != nil {
+		return err
+	}
+
 	SessionsMutex.Lock()
-	defer SessionsMutex.Unlock()
 
 	session, exists := Sessions[sessionID]
 	if !exists {
+		SessionsMutex.Unlock()
 		return fmt.Errorf("session %d not found", sessionID)
 	}
 
 	// Find and toggle the student's presence
 	found := false
+	var change attendanceChange
 	for i, student := range session.Students {
 		if student.SRN == srn {
 			session.Students[i].IsPresent = !session.Students[i].IsPresent
+			change = attendanceChange{SRN: srn, OldStatus: student.IsPresent, NewStatus: session.Students[i].IsPresent}
 			found = true
 			break
 		}
 	}
 
 	if !found {
+		SessionsMutex.Unlock()
 		return fmt.Errorf("student SRN %s not found in session %d", srn, sessionID)
 	}
 
 	// Save back the updated session
 	Sessions[sessionID] = session
+	SessionsMutex.Unlock()
 
 	// Notify about the change in a separate goroutine
 	go notifyAttendanceChange(sessionID)
 
-	return nil
+	return recordOverrides(sessionID, override, []attendanceChange{change})
 }
 
 // AttendanceChangeEvent represents a change in the attendance status
 type AttendanceChangeEvent struct {
-	SessionID  uint32
+	SessionID  models.ID
 	Absentees  []models.StudentInASession
 	Presentees []models.StudentInASession
 }
 
-// We'll use a map to store channels for each session
+// We'll use a map to store channels for each session. A session can have several
+// listeners: the teacher plus any delegates watching it
 var (
-	sessionEventChannels = make(map[uint32]chan AttendanceChangeEvent)
+	sessionEventChannels = make(map[models.ID]map[chan AttendanceChangeEvent]struct{})
 	eventChannelsMutex   sync.Mutex
 )
 
 // RegisterForAttendanceChanges creates and returns a channel that will receive
 // attendance change events for the specified session
-func RegisterForAttendanceChanges(sessionID uint32) chan AttendanceChangeEvent {
+func RegisterForAttendanceChanges(sessionID models.ID) chan AttendanceChangeEvent {
 	eventChannelsMutex.Lock()
 	defer eventChannelsMutex.Unlock()
 
 	// Create a buffered channel to prevent blocking
 	ch := make(chan AttendanceChangeEvent, 10)
-	sessionEventChannels[sessionID] = ch
+	if sessionEventChannels[sessionID] == nil {
+		sessionEventChannels[sessionID] = make(map[chan AttendanceChangeEvent]struct{})
+	}
+	sessionEventChannels[sessionID][ch] = struct{}{}
 	return ch
 }
 
-// UnregisterFromAttendanceChanges removes the event channel for a session
-func UnregisterFromAttendanceChanges(sessionID uint32) {
+// UnregisterFromAttendanceChanges removes one listener's event channel for a session
+func UnregisterFromAttendanceChanges(sessionID models.ID, ch chan AttendanceChangeEvent) {
 	eventChannelsMutex.Lock()
 	defer eventChannelsMutex.Unlock()
 
-	if ch, exists := sessionEventChannels[sessionID]; exists {
+	if _, exists := sessionEventChannels[sessionID][ch]; exists {
 		close(ch)
+		delete(sessionEventChannels[sessionID], ch)
+	}
+	if len(sessionEventChannels[sessionID]) == 0 {
 		delete(sessionEventChannels, sessionID)
 	}
 }
 
-// notifyAttendanceChange sends an attendance change event to any registered listeners
-func notifyAttendanceChange(sessionID uint32) {
+// closeAttendanceListeners closes every listener of an ended session
+func closeAttendanceListeners(sessionID models.ID) {
 	eventChannelsMutex.Lock()
-	ch, exists := sessionEventChannels[sessionID]
-	eventChannelsMutex.Unlock()
+	defer eventChannelsMutex.Unlock()
 
-	if !exists {
-		return
+	for ch := range sessionEventChannels[sessionID] {
+		close(ch)
 	}
+	delete(sessionEventChannels, sessionID)
+}
 
+// notifyAttendanceChange sends an attendance change event to any registered listeners
+func notifyAttendanceChange(sessionID models.ID) {
 	// Get current attendance lists
 	absentees, presentees, err := GetAttendanceList(sessionID)
 	if err != nil {
@@ -178,16 +322,116 @@ func notifyAttendanceChange(sessionID uint32) {
 		return
 	}
 
-	// Send event to channel in a non-blocking way
-	select {
-	case ch <- AttendanceChangeEvent{
-		SessionID:  sessionID,
-		Absentees:  absentees,
-		Presentees: presentees,
-	}:
-		// Event sent successfully
-	default:
-		// Channel buffer is full, log and continue
-		log.Printf("Event channel for session %d is full, dropping notification", sessionID)
+	eventChannelsMutex.Lock()
+	defer eventChannelsMutex.Unlock()
+
+	// Send event to every channel in a non-blocking way. Each listener gets its
+	// own copy of the lists since the teacher handler sorts them in place
+	for ch := range sessionEventChannels[sessionID] {
+		select {
+		case ch <- AttendanceChangeEvent{
+			SessionID:  sessionID,
+			Absentees:  slices.Clone(absentees),
+			Presentees: slices.Clone(presentees),
+		}:
+			// Event sent successfully
+		default:
+			// Channel buffer is full, log and continue
+			log.Printf("Event channel for session %d is full, dropping notification", sessionID)
+		}
+	}
+}
+
+// SetAttendanceForAll marks the whole roster present or absent in one go
+func SetAttendanceForAll(sessionID models.ID, isPresent bool, override models.ManualOverride) (int, error) {
+	return setAttendanceWhere(sessionID, isPresent, override, nil, func(student models.StudentInASession) bool {
+		return true
+	})
+}
+
+// SetAttendanceForSRNs marks the listed students. Nothing changes if any SRN isn't on the roster
+func SetAttendanceForSRNs(sessionID models.ID, srns []string, isPresent bool, override models.ManualOverride) (int, error) {
+	if len(srns) == 0 {
+		return 0, errors.New("no SRNs given")
+	}
+
+	// checked under the same lock the change is made under, so the roster can't change in between
+	onRoster := func(session models.Session) error {
+		for _, srn := range srns {
+			if !slices.ContainsFunc(session.Students, func(s models.StudentInASession) bool { return s.SRN == srn }) {
+				return fmt.Errorf("student SRN %s not found in session %d", srn, sessionID)
+			}
+		}
+		return nil
+	}
+	return setAttendanceWhere(sessionID, isPresent, override, onRoster, func(student models.StudentInASession) bool {
+		return slices.Contains(srns, student.SRN)
+	})
+}
+
+// SetAttendanceForSRNRange marks every student whose SRN ends in a number between from and to, inclusive
+func SetAttendanceForSRNRange(sessionID models.ID, from, to int, isPresent bool, override models.ManualOverride) (int, error) {
+	if from > to {
+		return 0, fmt.Errorf("invalid SRN range %d-%d", from, to)
+	}
+	return setAttendanceWhere(sessionID, isPresent, override, nil, func(student models.StudentInASession) bool {
+		suffix, ok := srnSuffix(student.SRN)
+		return ok && suffix >= from && suffix <= to
+	})
+}
+
+// setAttendanceWhere applies a bulk change atomically under SessionsMutex,
+// sends a single attendance update and audits every student whose status changed.
+// A non-nil check runs under the same lock first, and aborts the change if it fails
+func setAttendanceWhere(sessionID models.ID, isPresent bool, override models.ManualOverride, check func(models.Session) error, match func(models.StudentInASession) bool) (int, error) {
+	if err := override.Validate(); err != nil {
+		return 0, err
+	}
+
+	SessionsMutex.Lock()
+
+	session, exists := Sessions[sessionID]
+	if !exists {
+		SessionsMutex.Unlock()
+		return 0, fmt.Errorf("session %d not found", sessionID)
+	}
+	if check != nil {
+		if err := check(session); err != nil {
+			SessionsMutex.Unlock()
+			return 0, err
+		}
+	}
+
+	var changes []attendanceChange
+	for i, student := range session.Students {
+		if !match(student) || student.IsPresent == isPresent {
+			continue
+		}
+		session.Students[i].IsPresent = isPresent
+		changes = append(changes, attendanceChange{SRN: student.SRN, OldStatus: student.IsPresent, NewStatus: isPresent})
+	}
+
+	Sessions[sessionID] = session
+	SessionsMutex.Unlock()
+
+	if len(changes) == 0 {
+		return 0, nil
+	}
+
+	log.Printf("Bulk attendance change in session %d: %d students marked present=%t", sessionID, len(changes), isPresent)
+	go notifyAttendanceChange(sessionID)
+
+	return len(changes), recordOverrides(sessionID, override, changes)
+}
+
+// srnSuffix returns the trailing 3 digit roll number of an SRN, the same one the teacher's lists are sorted by
+func srnSuffix(srn string) (int, bool) {
+	if len(srn) < 3 {
+		return 0, false
+	}
+	suffix, err := strconv.Atoi(srn[len(srn)-3:])
+	if err != nil {
+		return 0, false
 	}
+	return suffix, true
 }
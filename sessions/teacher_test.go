@@ -0,0 +1,23 @@
+package sessions
+
+import (
+	"testing"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+func TestSetAttendanceForSRNsChecksRoster(t *testing.T) {
+	startTestSession(t, 0, false)
+
+	changed, err := SetAttendanceForSRNs(testSessionID, []string{testSRN, "PES1UG21CS999"}, true, models.ManualOverride{Actor: "T001"})
+	if err == nil || changed != 0 {
+		t.Fatalf("got %d changed and %v with an SRN off the roster, want an error and no change", changed, err)
+	}
+
+	SessionsMutex.Lock()
+	student := Sessions[testSessionID].Students[0]
+	SessionsMutex.Unlock()
+	if student.IsPresent {
+		t.Errorf("%s was marked present although the change was refused", student.SRN)
+	}
+}
@@ -0,0 +1,56 @@
+package sessions
+
+import (
+	"log"
+	"math"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+// how much of each new probe goes into the teacher latency estimate
+const teacherLatencySmoothing = 0.25
+
+// the most one periodic probe may say, in ms. A backgrounded tab can take seconds to paint,
+// and one such stall shouldn't widen every scan's window. The probe at connect time isn't
+// capped, it is the one measurement taken before the QR is on screen at all
+const maxTeacherLatencySample = 1000
+
+// RecordTeacherLatency folds one probe of the teacher's screen into the session's latency
+// estimate, which the following scans are adjusted by, and queues it for the scan audit.
+// The first probe is taken as is, later ones are capped and smoothed
+func RecordTeacherLatency(sessionID models.ID, roundTrip, renderLatency int64) (models.TeacherLatencyEstimate, error) {
+	sample := max(roundTrip/2+renderLatency, 0)
+
+	SessionsMutex.Lock()
+	session, exists := Sessions[sessionID]
+	if !exists {
+		SessionsMutex.Unlock()
+		return models.TeacherLatencyEstimate{}, ErrSessionNotFound
+	}
+
+	estimate := sample
+	if session.TeacherLatencyProbes > 0 {
+		sample = min(sample, maxTeacherLatencySample)
+		previous := session.TeacherQRRenderingLatency
+		estimate = previous + int64(math.Round(teacherLatencySmoothing*float64(sample-previous)))
+	}
+	entry := models.TeacherLatencyEstimate{
+		CreatedAt:     Now(),
+		SessionID:     sessionID,
+		Probe:         session.TeacherLatencyProbes,
+		RoundTrip:     roundTrip,
+		RenderLatency: renderLatency,
+		Sample:        sample,
+		Estimate:      estimate,
+	}
+	session.TeacherQRRenderingLatency = estimate
+	session.TeacherLatencyProbes++
+	Sessions[sessionID] = session
+	SessionsMutex.Unlock()
+
+	if entry.Probe > 0 {
+		log.Printf("Teacher latency probe %d in session %d: %d ms, estimate %d ms", entry.Probe, sessionID, sample, estimate)
+	}
+	queueTeacherLatency(entry)
+	return entry, nil
+}
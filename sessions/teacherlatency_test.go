@@ -0,0 +1,83 @@
+package sessions
+
+import (
+	"testing"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+func TestRecordTeacherLatency(t *testing.T) {
+	SetClock(&fakeClock{now: t0})
+	SessionsMutex.Lock()
+	Sessions[testSessionID] = models.Session{}
+	SessionsMutex.Unlock()
+	t.Cleanup(func() {
+		SessionsMutex.Lock()
+		delete(Sessions, testSessionID)
+		SessionsMutex.Unlock()
+		SetClock(nil)
+	})
+
+	probes := []struct {
+		roundTrip, renderLatency int64
+		wantSample, wantEstimate int64
+	}{
+		{roundTrip: 40, renderLatency: 30, wantSample: 50, wantEstimate: 50},   // the first probe is taken as is
+		{roundTrip: 40, renderLatency: 130, wantSample: 150, wantEstimate: 75}, // the laptop throttles
+		{roundTrip: 40, renderLatency: 130, wantSample: 150, wantEstimate: 94},
+		{roundTrip: 200, renderLatency: 5000, wantSample: 1000, wantEstimate: 321}, // a backgrounded tab is capped
+		{roundTrip: 40, renderLatency: 30, wantSample: 50, wantEstimate: 253},
+	}
+
+	for i, probe := range probes {
+		got, err := RecordTeacherLatency(testSessionID, probe.roundTrip, probe.renderLatency)
+		if err != nil {
+			t.Fatalf("probe %d: %v", i, err)
+		}
+		if got.Probe != i || got.Sample != probe.wantSample || got.Estimate != probe.wantEstimate {
+			t.Errorf("probe %d: got probe %d, sample %d, estimate %d, want probe %d, sample %d, estimate %d",
+				i, got.Probe, got.Sample, got.Estimate, i, probe.wantSample, probe.wantEstimate)
+		}
+	}
+
+	SessionsMutex.Lock()
+	session := Sessions[testSessionID]
+	SessionsMutex.Unlock()
+	if session.TeacherQRRenderingLatency != 253 || session.TeacherLatencyProbes != len(probes) {
+		t.Errorf("got session latency %d after %d probes, want 253 after %d", session.TeacherQRRenderingLatency, session.TeacherLatencyProbes, len(probes))
+	}
+
+	if _, err := RecordTeacherLatency(1, 40, 30); err != ErrSessionNotFound {
+		t.Errorf("got error %v for an unknown session, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func TestFirstTeacherLatencyProbeIsUncapped(t *testing.T) {
+	SetClock(&fakeClock{now: t0})
+	SessionsMutex.Lock()
+	Sessions[testSessionID] = models.Session{}
+	SessionsMutex.Unlock()
+	t.Cleanup(func() {
+		SessionsMutex.Lock()
+		delete(Sessions, testSessionID)
+		SessionsMutex.Unlock()
+		SetClock(nil)
+	})
+
+	// a slow projector at connect time is what every scan has to be adjusted by
+	got, err := RecordTeacherLatency(testSessionID, 200, 1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Sample != 1600 || got.Estimate != 1600 {
+		t.Errorf("got sample %d, estimate %d, want both 1600", got.Sample, got.Estimate)
+	}
+
+	got, err = RecordTeacherLatency(testSessionID, 200, 1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Sample != maxTeacherLatencySample || got.Estimate != 1450 {
+		t.Errorf("got sample %d, estimate %d, want %d, 1450", got.Sample, got.Estimate, maxTeacherLatencySample)
+	}
+}
@@ -0,0 +1,82 @@
+package sessions
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/anuragrao04/qr-attendance-backend/database"
+	"github.com/anuragrao04/qr-attendance-backend/models"
+	"gorm.io/gorm"
+)
+
+// a session opened this long before its slot starts is still matched to it
+const slotMatchGrace = 10 * time.Minute
+
+// matchTimetableSlot links a new session to the owner's timetable slot it covers, if any,
+// and records the slot as held on the day it falls on
+func matchTimetableSlot(sessionID models.ID, owner string, classroomTables []string, startedAt time.Time) uint {
+	slot, date, err := database.FindTimetableSlot(owner, classroomTables, startedAt, slotMatchGrace)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Failed to match session %d to the timetable: %v", sessionID, err)
+		}
+		return 0
+	}
+
+	err = database.RecordSlotOccurrence(models.SlotOccurrence{
+		SlotID:    slot.ID,
+		Date:      date.Format("2006-01-02"),
+		SessionID: sessionID,
+		Held:      true,
+	})
+	if err != nil {
+		log.Printf("Failed to record slot %d as held: %v", slot.ID, err)
+	}
+
+	log.Printf("Session %d matched to %s slot %d (%s-%s)", sessionID, slot.Course, slot.ID, slot.StartTime, slot.EndTime)
+	return slot.ID
+}
+
+// StartMissedClassScheduler periodically records timetable slots that ended without a session
+func StartMissedClassScheduler(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			recordMissedClasses(Now())
+			<-ticker.C
+		}
+	}()
+}
+
+func recordMissedClasses(now time.Time) {
+	// yesterday too, in case the server was down when its last slots ended
+	days := []struct {
+		date        time.Time
+		endedBefore string
+	}{
+		{now.AddDate(0, 0, -1), "24:00"},
+		{now, now.Format("15:04")},
+	}
+
+	for _, day := range days {
+		slots, err := database.GetUnrecordedSlots(day.date, day.endedBefore)
+		if err != nil {
+			log.Printf("Failed to look up missed classes: %v", err)
+			return
+		}
+		for _, slot := range slots {
+			err := database.RecordSlotOccurrence(models.SlotOccurrence{
+				SlotID: slot.ID,
+				Date:   day.date.Format("2006-01-02"),
+				Held:   false,
+			})
+			if err != nil {
+				log.Printf("Failed to record slot %d as missed: %v", slot.ID, err)
+				continue
+			}
+			log.Printf("No session was held for %s slot %d on %s", slot.Course, slot.ID, day.date.Format("2006-01-02"))
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package sessions
+
+import (
+	"log"
+	"math"
+
+	"github.com/anuragrao04/qr-attendance-backend/database"
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+// MaxExtraTolerance is the most slack a timing profile can add to a student's scan window, in ms
+const MaxExtraTolerance = 150
+
+// how many matched scans, from how many sessions, a profile needs before it gives any slack.
+// One bad classroom network or one stale QR shouldn't buy a student slack in every class
+const (
+	minProfileScans    = 5
+	minProfileSessions = 3
+)
+
+// how much of each scan goes into the profile's averages
+const timingProfileSmoothing = 0.2
+
+// a phone that scans the moment a QR appears, on average halfway through its lifetime,
+// lands this long before the QR expires
+var expectedExpiryDelta = -RandomIDLifetime.Milliseconds() / 2
+
+// ExtraTolerance is the slack a student's timing profile grants, 0 if it can't be read
+func ExtraTolerance(SRN string) int64 {
+	profile, err := database.GetStudentTimingProfile(SRN)
+	if err != nil {
+		log.Printf("Failed to read the timing profile of %s: %v", SRN, err)
+		return 0
+	}
+	return profile.ExtraTolerance
+}
+
+// updateTimingProfiles folds a batch of scan attempts into the students' profiles
+func updateTimingProfiles(attempts []models.ScanAttempt) {
+	profiles := make(map[string]*models.StudentTimingProfile)
+	for _, attempt := range attempts {
+		if !countsTowardsProfile(attempt) {
+			continue
+		}
+		profile, exists := profiles[attempt.SRN]
+		if !exists {
+			loaded, err := database.GetStudentTimingProfile(attempt.SRN)
+			if err != nil {
+				log.Printf("Failed to read the timing profile of %s: %v", attempt.SRN, err)
+				continue
+			}
+			profile = &loaded
+			profiles[attempt.SRN] = profile
+		}
+		foldTimingProfile(profile, attempt)
+	}
+
+	for _, profile := range profiles {
+		if err := database.SaveStudentTimingProfile(profile); err != nil {
+			log.Printf("Failed to save the timing profile of %s: %v", profile.SRN, err)
+		}
+	}
+}
+
+// only scans that matched a QR say anything about the phone's timing. Expired scans
+// past the most slack a profile could ever give are a stale QR, not a slow phone
+func countsTowardsProfile(attempt models.ScanAttempt) bool {
+	switch attempt.Outcome {
+	case models.ScanOutcomeAccepted, models.ScanOutcomePendingApproval:
+		return true
+	}
+	return attempt.FailureReason == ErrTokenExpired.Code && attempt.ExpiryDelta <= scanTolerance+MaxExtraTolerance
+}
+
+// foldTimingProfile adds one scan to a profile and works out the student's slack again.
+// The expiry delta is measured before any slack is applied, so slack never feeds itself
+func foldTimingProfile(profile *models.StudentTimingProfile, attempt models.ScanAttempt) {
+	if profile.Scans == 0 {
+		profile.ClockDrift = attempt.ClockDrift
+		profile.StudentLatency = attempt.StudentLatency
+		profile.ExpiryDelta = attempt.ExpiryDelta
+	} else {
+		profile.ClockDriftJitter = smooth(profile.ClockDriftJitter, abs(attempt.ClockDrift-profile.ClockDrift))
+		profile.ClockDrift = smooth(profile.ClockDrift, attempt.ClockDrift)
+		profile.StudentLatency = smooth(profile.StudentLatency, attempt.StudentLatency)
+		profile.ExpiryDelta = smooth(profile.ExpiryDelta, attempt.ExpiryDelta)
+	}
+	profile.Scans++
+	if attempt.FailureReason == ErrTokenExpired.Code {
+		profile.LateScans++
+	}
+	// attempts arrive in the order they were made, so a student's sessions don't interleave
+	if attempt.SessionID != profile.LastSessionID {
+		profile.Sessions++
+		profile.LastSessionID = attempt.SessionID
+	}
+
+	if profile.Scans >= minProfileScans && profile.Sessions >= minProfileSessions {
+		profile.ExtraTolerance = min(max(profile.ExpiryDelta-expectedExpiryDelta, 0), MaxExtraTolerance)
+	}
+}
+
+func smooth(average, value int64) int64 {
+	return average + int64(math.Round(timingProfileSmoothing*float64(value-average)))
+}
@@ -0,0 +1,106 @@
+package sessions
+
+import (
+	"testing"
+
+	"github.com/anuragrao04/qr-attendance-backend/models"
+)
+
+func TestFoldTimingProfile(t *testing.T) {
+	accepted := func(expiryDelta int64) models.ScanAttempt {
+		return models.ScanAttempt{ClockDrift: 2000, StudentLatency: 40, ExpiryDelta: expiryDelta, Outcome: models.ScanOutcomeAccepted}
+	}
+	late := func(expiryDelta int64) models.ScanAttempt {
+		return models.ScanAttempt{ClockDrift: 2000, StudentLatency: 40, ExpiryDelta: expiryDelta, Outcome: models.ScanOutcomeRejected, FailureReason: ErrTokenExpired.Code}
+	}
+
+	tests := []struct {
+		name          string
+		attempts      []models.ScanAttempt
+		sessions      []models.ID // of each attempt, each in its own session if nil
+		wantExtra     int64
+		wantScans     int
+		wantLateScans int
+	}{
+		{
+			name:      "on time phone gets no slack",
+			attempts:  []models.ScanAttempt{accepted(-150), accepted(-50), accepted(-120), accepted(-80), accepted(-100), accepted(-90)},
+			wantScans: 6,
+		},
+		{
+			name:          "slow phone gets the lag it keeps showing",
+			attempts:      []models.ScanAttempt{late(150), late(150), late(150), late(150), late(150)},
+			wantExtra:     MaxExtraTolerance, // 250 ms behind, capped
+			wantScans:     5,
+			wantLateScans: 5,
+		},
+		{
+			name:          "moderately slow phone",
+			attempts:      []models.ScanAttempt{accepted(-40), accepted(-40), late(-40), accepted(-40), accepted(-40)},
+			wantExtra:     60,
+			wantScans:     5,
+			wantLateScans: 1,
+		},
+		{
+			name:          "too few scans to judge",
+			attempts:      []models.ScanAttempt{late(200), late(200), late(200), late(200)},
+			wantScans:     4,
+			wantLateScans: 4,
+		},
+		{
+			name:          "one slow session is not a slow phone",
+			attempts:      []models.ScanAttempt{late(150), late(150), late(150), late(150), late(150), late(150)},
+			sessions:      []models.ID{1, 1, 1, 2, 2, 2},
+			wantScans:     6,
+			wantLateScans: 6,
+		},
+		{
+			name:      "stale QRs earn no slack",
+			attempts:  []models.ScanAttempt{late(5000), late(5000), late(5000), late(5000), late(5000)},
+			wantScans: 0,
+		},
+		{
+			name:      "a stale QR doesn't drag an on time phone",
+			attempts:  []models.ScanAttempt{accepted(-150), accepted(-150), late(5000), accepted(-150), accepted(-150), accepted(-150)},
+			wantScans: 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var profile models.StudentTimingProfile
+			for i, attempt := range tt.attempts {
+				attempt.SessionID = models.ID(i + 1)
+				if tt.sessions != nil {
+					attempt.SessionID = tt.sessions[i]
+				}
+				if countsTowardsProfile(attempt) {
+					foldTimingProfile(&profile, attempt)
+				}
+			}
+			if profile.ExtraTolerance != tt.wantExtra {
+				t.Errorf("got extra tolerance %d, want %d", profile.ExtraTolerance, tt.wantExtra)
+			}
+			if profile.Scans != tt.wantScans || profile.LateScans != tt.wantLateScans {
+				t.Errorf("got %d scans, %d late, want %d, %d late", profile.Scans, profile.LateScans, tt.wantScans, tt.wantLateScans)
+			}
+			if profile.Scans > 0 && (profile.ClockDrift != 2000 || profile.StudentLatency != 40) {
+				t.Errorf("got drift %d, latency %d", profile.ClockDrift, profile.StudentLatency)
+			}
+		})
+	}
+}
+
+func TestCountsTowardsProfile(t *testing.T) {
+	rejected := []models.ScanAttempt{
+		{Outcome: models.ScanOutcomeRejected, FailureReason: ErrTokenUnknown.Code},
+		{Outcome: models.ScanOutcomeRejected, FailureReason: ErrAlreadyPresent.Code},
+		{Outcome: models.ScanOutcomeRejected, FailureReason: ErrSessionNotFound.Code},
+		{Outcome: models.ScanOutcomeRejected, FailureReason: ErrTokenExpired.Code, ExpiryDelta: scanTolerance + MaxExtraTolerance + 1},
+	}
+	for _, attempt := range rejected {
+		if countsTowardsProfile(attempt) {
+			t.Errorf("%s %d ms past expiry should not count towards the profile", attempt.FailureReason, attempt.ExpiryDelta)
+		}
+	}
+}